@@ -0,0 +1,80 @@
+package adbtools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// adbServerAddress returns the adb server address following the same
+// environment variables as the adb client
+func adbServerAddress() string {
+	if socket := os.Getenv("ADB_SERVER_SOCKET"); strings.HasPrefix(socket, "tcp:") {
+		address := strings.TrimPrefix(socket, "tcp:")
+		if !strings.Contains(address, ":") {
+			return "localhost:" + address
+		}
+		return address
+	}
+	if port := os.Getenv("ANDROID_ADB_SERVER_PORT"); len(port) > 0 {
+		return "localhost:" + port
+	}
+	return "localhost:5037"
+}
+
+// dialADB connects to the adb server; the connection is closed when the context is done
+func dialADB(ctx context.Context) (net.Conn, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", adbServerAddress())
+	if err != nil {
+		return nil, fmt.Errorf("dialer.DialContext err: %v", err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	return conn, nil
+}
+
+// sendRequest sends a host protocol request and reads its OKAY or FAIL status
+func sendRequest(conn net.Conn, request string) error {
+	if _, err := fmt.Fprintf(conn, "%04x%s", len(request), request); err != nil {
+		return fmt.Errorf("failed to send '%s': %v", request, err)
+	}
+	status := make([]byte, 4)
+	if _, err := io.ReadFull(conn, status); err != nil {
+		return fmt.Errorf("failed to read '%s' status: %v", request, err)
+	}
+	switch string(status) {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		message, err := readMessage(conn)
+		if err != nil {
+			return fmt.Errorf("'%s' failed: %v", request, err)
+		}
+		return fmt.Errorf("'%s' failed: %s", request, message)
+	}
+	return fmt.Errorf("invalid '%s' status: %q", request, status)
+}
+
+// readMessage reads a host protocol message prefixed with its hex length
+func readMessage(r io.Reader) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid message length %q", header)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return "", err
+	}
+	return string(message), nil
+}
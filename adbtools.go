@@ -1,13 +1,15 @@
 package adbtools
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
+	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	shell "github.com/ozzono/go-shell"
@@ -19,15 +21,48 @@ type Device struct {
 	ID           string
 	Log          bool
 	dumpPath     string
+	crashes      *CrashWatcher
+	settings     *SettingsSnapshot
+	watchers     *watcherSet
 	DefaultSleep int
-	Screen       struct {
+	// DisplayID selects the display of the input and screen capture
+	// commands; 0 is the default display
+	DisplayID int
+	Screen    struct {
 		Width  int
 		Height int
 	}
 }
 
-// TODO: Validate the need of the given commands
-// free memory verification and storage: adb shell cat /proc/meminfo |grep MemFree
+// locks holds one mutex per device ID so that every copy
+// of the same Device shares it
+var locks sync.Map
+
+func (device *Device) mutex() *sync.Mutex {
+	mu, _ := locks.LoadOrStore(device.ID, &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+// locked runs fn holding the device lock; it guards the
+// fields the methods update by themselves
+func (device *Device) locked(fn func()) {
+	mu := device.mutex()
+	mu.Lock()
+	defer mu.Unlock()
+	fn()
+}
+
+// defaultSleep returns device.DefaultSleep, setting it to 100 when unset
+func (device *Device) defaultSleep() int {
+	sleep := 0
+	device.locked(func() {
+		if device.DefaultSleep == 0 {
+			device.DefaultSleep = 100
+		}
+		sleep = device.DefaultSleep
+	})
+	return sleep
+}
 
 // Shell executes the given command in the Linux bash terminal
 // and return the command output as string
@@ -38,9 +73,7 @@ func (device *Device) Shell(arg string) string {
 	if device.Log {
 		log.Println(arg)
 	}
-	if device.DefaultSleep == 0 {
-		device.DefaultSleep = 100
-	}
+	device.defaultSleep()
 	out, err := shell.Cmd(arg)
 	if err != nil {
 		return fmt.Sprintf("shell.Cmd err: %v", err)
@@ -48,6 +81,26 @@ func (device *Device) Shell(arg string) string {
 	return out
 }
 
+// command prepares an adb command targeting the device.
+// Meant for calls that need to stream their output
+func (device *Device) command(ctx context.Context, args ...string) *exec.Cmd {
+	if len(device.ID) > 0 {
+		args = append([]string{"-s", device.ID}, args...)
+	}
+	if device.Log {
+		log.Printf("adb %s", strings.Join(args, " "))
+	}
+	return exec.CommandContext(ctx, "adb", args...)
+}
+
+// input prepares an input command for the device display
+func (device *Device) input(args string) string {
+	if device.DisplayID != 0 {
+		return fmt.Sprintf("adb shell input -d %d %s", device.DisplayID, args)
+	}
+	return "adb shell input " + args
+}
+
 // Foreground verifies if the given package is on foreground
 func (device *Device) Foreground() string {
 	if device.Log {
@@ -62,29 +115,54 @@ func (device *Device) TapScreen(x, y, delay int) {
 	if device.Log {
 		log.Printf("tapping [%d,%d]", x, y)
 	}
-	device.Shell(fmt.Sprintf("adb shell input tap %d %d", x, y))
+	device.Shell(device.input(fmt.Sprintf("tap %d %d", x, y)))
 	device.sleep(delay)
 	return
 }
 
 func (device *Device) sleep(delay int) {
-	if device.DefaultSleep == 0 {
-		device.DefaultSleep = 100
-	}
-	time.Sleep(time.Duration(device.DefaultSleep*delay) * time.Millisecond)
+	time.Sleep(time.Duration(device.defaultSleep()*delay) * time.Millisecond)
 }
 
-// XMLScreen fetches the screen xml data
+// XMLScreen fetches the screen xml data.
+//
+// New dumps are checked by the registered watchers; when one fires
+// the screen is dumped again, and when one fails it returns a *WatcherError
 func (device *Device) XMLScreen(newdump bool) (string, error) {
+	screen, err := device.dumpScreen(newdump)
+	if err != nil || !newdump {
+		return screen, err
+	}
+	for attempts := 3; attempts > 0; attempts-- {
+		fired, err := device.runWatchers(screen)
+		if err != nil {
+			return "", err
+		}
+		if !fired {
+			break
+		}
+		device.sleep(5)
+		if screen, err = device.dumpScreen(true); err != nil {
+			return "", err
+		}
+	}
+	return screen, nil
+}
+
+func (device *Device) dumpScreen(newdump bool) (string, error) {
 	if device.Log {
 		log.Println("dumping screen xml")
 	}
-	if len(device.dumpPath) == 0 {
-		device.dumpPath = "/sdcard/window_dump.xml"
-		if device.Log {
-			log.Println("setting default dump path")
+	dumpPath := ""
+	device.locked(func() {
+		if len(device.dumpPath) == 0 {
+			device.dumpPath = "/sdcard/window_dump.xml"
+			if device.Log {
+				log.Println("setting default dump path")
+			}
 		}
-	}
+		dumpPath = device.dumpPath
+	})
 	if newdump {
 		output := device.Shell("adb shell uiautomator dump")
 		max := 10
@@ -100,15 +178,17 @@ func (device *Device) XMLScreen(newdump bool) (string, error) {
 		if !strings.Contains(output, "xml") {
 			return "", fmt.Errorf("failed to dump xml screen; output: %v", output)
 		}
-		dumpPath := cleanString(strings.TrimPrefix(output, "UI hierchary dumped to: "))
-		if device.dumpPath != dumpPath {
-			device.dumpPath = dumpPath
-			if device.Log {
-				log.Printf("resetting default dump path to '%s'", device.dumpPath)
+		dumpPath = cleanString(strings.TrimPrefix(output, "UI hierchary dumped to: "))
+		device.locked(func() {
+			if device.dumpPath != dumpPath {
+				device.dumpPath = dumpPath
+				if device.Log {
+					log.Printf("resetting default dump path to '%s'", device.dumpPath)
+				}
 			}
-		}
+		})
 	}
-	return device.Shell(fmt.Sprintf("adb shell cat %s", device.dumpPath)), nil
+	return device.Shell(fmt.Sprintf("adb shell cat %s", dumpPath)), nil
 }
 
 // TapCleanInput tap and cleans the input
@@ -118,9 +198,9 @@ func (device *Device) TapCleanInput(x, y, charcount int) {
 	}
 	charcount = charcount/2 + 1
 	device.TapScreen(x, y, 0)
-	device.Shell("adb shell input keyevent KEYCODE_MOVE_END")
+	device.Shell(device.input("keyevent KEYCODE_MOVE_END"))
 	for i := 0; i < charcount; i++ {
-		device.Shell(`adb shell input keyevent --longpress $(printf 'KEYCODE_DEL %.0s' {1..2})`)
+		device.Shell(device.input(`keyevent --longpress $(printf 'KEYCODE_DEL %.0s' {1..2})`))
 	}
 }
 
@@ -129,7 +209,7 @@ func (device *Device) Swipe(coords [4]int) {
 	if device.Log {
 		log.Printf("swiping from [%d,%d] to [%d,%d]", coords[0], coords[1], coords[2], coords[3])
 	}
-	device.Shell(fmt.Sprintf("adb shell input swipe %d %d %d %d", coords[0], coords[1], coords[2], coords[3]))
+	device.Shell(device.input(fmt.Sprintf("swipe %d %d %d %d", coords[0], coords[1], coords[2], coords[3])))
 }
 
 // CloseApp closes the app
@@ -164,47 +244,44 @@ func (device *Device) InputText(text string, splitted bool) error {
 	text = strings.Replace(text, " ", "\\s", -1)
 	if splitted {
 		for i := range text {
-			device.Shell(fmt.Sprintf("adb shell input text %v", string(text[i])))
+			device.Shell(device.input(fmt.Sprintf("text %v", string(text[i]))))
 		}
 		return nil
 	}
-	device.Shell("adb shell input text " + text)
+	device.Shell(device.input("text " + text))
 	return nil
 }
 
 // PageDown scrolls down a fixed amount of pixels
 func (device *Device) PageDown() {
 	// code 93 is equivalent to "KEYCODE_PAGE_DOWN"
-	device.Shell("adb shell input keyevent 93")
+	device.Shell(device.input("keyevent 93"))
 }
 
 // PageUp scrolls up a fixed amount of pixels
 func (device *Device) PageUp() {
 	// code 92 is equivalent to "KEYCODE_PAGE_UP"
-	device.Shell("adb shell input keyevent 92")
+	device.Shell(device.input("keyevent 92"))
 }
 
 // Devices returns all the connected devices´ ID
 func Devices(Log bool) ([]Device, error) {
 	output := []Device{}
-	count := 0
-	out, err := shell.Cmd("adb devices")
+	list, err := ListDevices()
 	if err != nil {
-		return nil, fmt.Errorf("shell.Cmd err: %v", err)
-	}
-	for _, row := range strings.Split(out, "\n") {
-		if strings.HasSuffix(row, "device") {
-			output = append(output, Device{ID: strings.Split(row, "	")[0], Log: Log, DefaultSleep: 100})
-			count++
-		} else if strings.HasSuffix(row, "offline") {
-			id := strings.Split(row, "	")[0]
-			log.Printf("%s device is offline", id)
+		return nil, err
+	}
+	for _, info := range list {
+		if info.State != StateDevice {
+			log.Printf("%s device is %s", info.Serial, info.State)
+			continue
 		}
+		output = append(output, Device{ID: info.Serial, Log: Log, DefaultSleep: 100})
 	}
-	if count == 0 {
+	if len(output) == 0 {
 		return nil, fmt.Errorf("no devices found")
 	}
-	log.Printf("device count: %d\n", count)
+	log.Printf("device count: %d\n", len(output))
 	return output, nil
 }
 
@@ -293,33 +370,13 @@ func StartAVD(verified bool, deviceName string) (func(), error) {
 
 // DeviceReady returns the readiness state of the device
 func (device *Device) DeviceReady() bool {
-	return cleanString(device.Shell("adb shell getprop sys.boot_completed")) == "1"
+	return device.Prop("sys.boot_completed") == "1"
 }
 
 // WaitDeviceReady waits until the device.
 // It's specially useful after a fresh boot.
 func (device *Device) WaitDeviceReady(attemptCount int) error {
-	attempts := attemptCount
-	if device.DefaultSleep == 0 {
-		if device.Log {
-			log.Println("setting default sleep to 100ms")
-		}
-		device.DefaultSleep = 100
-	}
-	for !device.DeviceReady() && attempts > 0 {
-		if attempts == 0 {
-			return fmt.Errorf("reached max retry attempts of %d", attemptCount)
-		}
-		if device.Log {
-			log.Printf("waiting app load; %d attempts left", attempts)
-		}
-		device.sleep(10)
-		if device.DeviceReady() {
-			break
-		}
-		attempts--
-	}
-	return nil
+	return device.WaitProp("sys.boot_completed", "1", attemptCount)
 }
 
 // StartApp requires the package name with format com.packagename
@@ -362,7 +419,12 @@ func (device *Device) ScreenRecord(filename string, duration int) {
 
 // ScreenCap captures the screen as png
 func (device *Device) ScreenCap(filename string) {
-	device.Shell("adb shell screencap /sdcard/" + filename)
+	flag, err := device.screencapFlag()
+	if err != nil {
+		log.Printf("ScreenCap err: %v", err)
+		return
+	}
+	device.Shell(fmt.Sprintf("adb shell screencap%s /sdcard/%s", flag, filename))
 }
 
 // Root enables all adb commands to be run as root.
@@ -407,18 +469,21 @@ func XMLtoCoords(xmlcoords string) ([2]int, error) {
 	return [2]int{x, y}, nil
 }
 
-// Orientation returns the devices orientation
+// Orientation returns the devices orientation as the surface rotation index
 //
 // 0: portrait
 //
 // 1: landscape
+//
+// 2: reversed portrait
+//
+// 3: reversed landscape
 func (device *Device) Orientation() (int, error) {
-	output := device.Shell("adb shell dumpsys input | grep 'SurfaceOrientation' | awk '{ print $2 }'")
-	orientation, err := strconv.Atoi(output)
+	rotation, err := device.Rotation()
 	if err != nil {
-		return 0, fmt.Errorf("Failed to fetch device's orientation: %v", output)
+		return 0, fmt.Errorf("Failed to fetch device's orientation: %v", err)
 	}
-	return orientation, nil
+	return rotation.index(), nil
 }
 
 //PowerButton emulates the pressing of the power button
@@ -428,12 +493,14 @@ func (device *Device) PowerButton() {
 }
 
 // AutoRotate enables or disables the device auto rotation behaviour
-func (device *Device) AutoRotate(rotate bool) {
+//
+// Returns a function to be deferred setting the previous behaviour back
+func (device *Device) AutoRotate(rotate bool) (func(), error) {
+	value := "0"
 	if rotate {
-		device.Shell("adb shell content insert --uri content://settings/system --bind name:s:accelerometer_rotation --bind value:i:1")
-	} else {
-		device.Shell("adb shell content insert --uri content://settings/system --bind name:s:accelerometer_rotation --bind value:i:0")
+		value = "1"
 	}
+	return device.PutSetting(SettingsSystem, "accelerometer_rotation", value)
 }
 
 // Activities returns all package's activities
@@ -469,10 +536,18 @@ func (device *Device) Shutdown() {
 //
 // Waits for given miliseconds after each try.
 //
-// Note: Has limited retry count
+// Note: Has limited retry count and gives up when a watched package crashes
 func (device *Device) WaitApp(pkg string, delay, maxRetry int) bool {
 	for !strings.Contains(device.Foreground(), pkg) {
-		device.sleep(device.DefaultSleep * delay)
+		if err := device.wait(device.defaultSleep() * delay); err != nil {
+			log.Printf("stopped waiting %s: %v", pkg, err)
+			return false
+		}
+		// the foreground check does not dump the screen, so the watchers get their own dump
+		if err := device.watchScreen(); err != nil {
+			log.Printf("stopped waiting %s: %v", pkg, err)
+			return false
+		}
 
 		if maxRetry == 0 {
 			log.Println("Reached max retry count")
@@ -500,21 +575,36 @@ func (device *Device) WakeUp() {
 	device.Shell("adb shell input keyevent KEYCODE_WAKEUP")
 }
 
-//ScreenSize fetches the physical screen size and return its height and width
+//ScreenSize fetches the screen size in use, either overridden or physical,
+// and return its height and width as seen in the current rotation;
+// in landscape the width is the longest side
 func (device *Device) ScreenSize() error {
 	if device.Log {
 		log.Println("fetching screen dimensions")
 	}
-	screen := device.Shell("adb shell wm size")
-	if !regexp.MustCompile("Physical size: (\\d+x\\d+)").MatchString(screen) {
-		return fmt.Errorf("Failed to fetch physical screen size; output: %s", screen)
+	info, err := device.Display()
+	if err != nil {
+		return err
+	}
+	width, height := info.Size().Width, info.Size().Height
+	// wm size reports the natural orientation size
+	if rotation, err := device.Rotation(); err == nil && (rotation == Rotation90 || rotation == Rotation270) {
+		width, height = height, width
 	}
-	sizes := strings.Split(strings.TrimPrefix(screen, "Physical size: "), "x")
-	device.Screen.Width, _ = strconv.Atoi(cleanString(sizes[0]))
-	device.Screen.Height, _ = strconv.Atoi(cleanString(sizes[1]))
+	device.locked(func() {
+		device.Screen.Width = width
+		device.Screen.Height = height
+	})
 	return nil
 }
 
+// screen returns the size fetched by ScreenSize, holding the device lock
+func (device *Device) screen() (int, int) {
+	width, height := 0, 0
+	device.locked(func() { width, height = device.Screen.Width, device.Screen.Height })
+	return width, height
+}
+
 // IsScreenON verifies if the is on
 func (device *Device) IsScreenON() bool {
 	if device.Log {
@@ -525,49 +615,60 @@ func (device *Device) IsScreenON() bool {
 
 //HasInScreen verifies if the wanted text appear on screen
 func (device *Device) HasInScreen(newDump bool, want ...string) bool {
-	newWant := make([]string, len(want))
-	j := copy(newWant, want)
-	if j != len(want) {
-		log.Printf("something went wrong on copying %v; copied items: %d", want, j)
-		return false
+	found, err := device.hasInScreen(newDump, want...)
+	if err != nil {
+		log.Printf("XMLScreen err: %v", err)
 	}
+	return found
+}
+
+// hasInScreen searches a single screen dump for any of the wanted texts
+func (device *Device) hasInScreen(newDump bool, want ...string) (bool, error) {
 	if device.Log {
-		log.Printf("has in screen: '%s'", strings.Join(newWant, "' or '"))
+		log.Printf("has in screen: '%s'", strings.Join(want, "' or '"))
 	}
-	for len(newWant) > 0 {
-		screen, err := device.XMLScreen(newDump)
-		if err != nil {
-			log.Printf("XMLScreen err: %v", err)
-			return false
-		}
-
-		i := rand.New(rand.NewSource(time.Now().UnixNano())).Intn(len(newWant))
+	screen, err := device.XMLScreen(newDump)
+	if err != nil {
+		return false, err
+	}
+	screen = strings.ToLower(normalize.Norm(screen))
+	for _, item := range want {
+		item = strings.ToLower(normalize.Norm(item))
 		if device.Log {
-			log.Printf("Searching screen %s", strings.ToLower(normalize.Norm(newWant[i])))
+			log.Printf("Searching screen %s", item)
 		}
-
-		if strings.Contains(
-			strings.ToLower(normalize.Norm(screen)),
-			strings.ToLower(normalize.Norm(newWant[i])),
-		) {
-			return true
+		if strings.Contains(screen, item) {
+			return true, nil
 		}
-		newWant = append(newWant[:i], newWant[i+1:]...)
 	}
-	return false
+	return false, nil
 }
 
 // WaitInScreen waits until the wanted text appear on screen.
 // It requires a max retry count to avoid endless loop.
+// When a watched package crashes it fails with a *CrashError,
+// and when a watcher fails it fails with a *WatcherError.
 func (device *Device) WaitInScreen(attemptCount int, want ...string) error {
 	if device.Log {
 		log.Printf("wait in screen: %s", strings.Join(want, " or "))
 	}
 	attempts := attemptCount
-	if device.DefaultSleep == 0 {
+	invalid := false
+	device.locked(func() { invalid = device.DefaultSleep == 0 })
+	if invalid {
 		return fmt.Errorf("Invalid device.DefaultSleep; must be > 0")
 	}
-	for !device.HasInScreen(true, want...) {
+	for {
+		found, err := device.hasInScreen(true, want...)
+		if found {
+			return nil
+		}
+		if _, ok := err.(*WatcherError); ok {
+			return err
+		}
+		if err != nil {
+			log.Printf("XMLScreen err: %v", err)
+		}
 		attempts--
 		if attempts == 0 {
 			return fmt.Errorf("Reached max retry attempts of %d", attemptCount)
@@ -575,84 +676,23 @@ func (device *Device) WaitInScreen(attemptCount int, want ...string) error {
 		if device.Log {
 			log.Printf("Waiting app load; %d attempts left", attempts)
 		}
-		device.sleep(10)
+		if err := device.wait(10); err != nil {
+			return err
+		}
 	}
-	return nil
 }
 
+// ScreenTimeout sets the screen off timeout; the device rounds it to milliseconds
 //
-// ScreenTimeout sets the screen off timeout to key1 seconds
-//
-// Returns a function to be deferred setting timeout to key2 seconds
-//
-// Accepts only the following time intervals:
-//
-// 15s
-//
-// 30s
-//
-// 1m
-//
-// 2m
-//
-// 5m
-//
-// 10m
-//
-// 30m
-func (device *Device) ScreenTimeout(waitTime string) (func(), error) {
-	if device.Log {
-		log.Printf("setting screen off timeout to %s", waitTime)
-	}
-	// set and validate waitTime
-	timeout := map[string]string{
-		"15s": "15000",
-		"30s": "30000",
-		"1m":  "60000",
-		"2m":  "120000",
-		"5m":  "300000",
-		"10m": "600000",
-		"30m": "1800000",
-	}
-	invalid := true
-	for mapKey := range timeout {
-		if waitTime == mapKey {
-			invalid = false
-			break
-		}
-	}
-	if invalid {
-		fmtTimeout := "wait time must be one of the following:\n"
-		for key := range timeout {
-			fmtTimeout += fmt.Sprintf("key: % 3s\n", key)
-		}
-		return func() {}, fmt.Errorf("invalid keys:\n%s", fmtTimeout)
-	}
-
-	current := cleanString(device.Shell("adb shell settings get system screen_off_timeout"))
-	if timeout[waitTime] == current {
-		return func() {}, nil
+// Returns a function to be deferred setting the previous timeout back
+func (device *Device) ScreenTimeout(timeout time.Duration) (func(), error) {
+	if timeout < time.Second {
+		return func() {}, fmt.Errorf("invalid screen off timeout %v; must be at least 1s", timeout)
 	}
-
-	// sets screen off timeout
 	if device.Log {
-		log.Printf("Setting screen off timeout to %ss", strings.TrimSuffix(timeout[waitTime], "000"))
-	}
-
-	output := device.Shell(fmt.Sprintf("adb shell settings put system screen_off_timeout %s", timeout[waitTime]))
-	if len(output) > 0 {
-		return func() {}, fmt.Errorf("Failed to set screen_off_timeout: %s", output)
+		log.Printf("setting screen off timeout to %v", timeout)
 	}
-	return func() {
-		if device.Log {
-			log.Printf("Setting screen off timeout back to %ss", current)
-		}
-		output := device.Shell(fmt.Sprintf("adb shell settings put system screen_off_timeout %s", current))
-		if len(output) > 0 {
-			log.Printf("Failed to set screen_off_timeout: %s", output)
-			return
-		}
-	}, nil
+	return device.PutSetting(SettingsSystem, "screen_off_timeout", strconv.FormatInt(timeout.Milliseconds(), 10))
 }
 
 // NodeList returns the unnested list of xml nodes
@@ -758,29 +798,3 @@ func checkEnv(path, device string) error {
 	log.Println("Successfully verified environment settings")
 	return nil
 }
-
-//TODO: this method requires revision
-// func (device *Device) Portrait() error {
-// 	orientation, err := device.Orientation()
-// 	if err != nil {
-// 		return fmt.Errorf("Failed to fetch the orientation: %v", err)
-// 	}
-// 	if orientation == 1 {
-// 		device.AutoRotate(false)
-// 		device.Shell("adb shell input keyevent 26")
-// 	}
-// 	return nil
-// }
-
-//TODO: this method requires revision
-// func (device *Device) Landscape() error {
-// 	orientation, err := device.Orientation()
-// 	if err != nil {
-// 		return fmt.Errorf("Failed to fetch the orientation: %v", err)
-// 	}
-// 	if orientation == 1 {
-// 		device.AutoRotate(false)
-// 		device.Shell("adb shell input keyevent 26")
-// 	}
-// 	return nil
-// }
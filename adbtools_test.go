@@ -172,9 +172,8 @@ func (t *testData) testStartApp() error {
 
 func (t *testData) testWaitInScreen() error {
 	t.test.Log("testing WaitInScreen; using chrome as test app")
-	if !strings.Contains(t.device.ID, "emulator") {
-		t.device.WakeUp()
-		t.device.Swipe([4]int{int(t.device.Screen.Width / 2), t.device.Screen.Height - 100, int(t.device.Screen.Width / 2), 100})
+	if err := t.device.Unlock(Credential{Type: CredentialSwipe}); err != nil {
+		return err
 	}
 	if err := t.device.WaitInScreen(5, "Search or type web address"); err != nil {
 		return err
@@ -0,0 +1,213 @@
+package adbtools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// AppDataTransport is the way app data is read from and written to the device
+type AppDataTransport int
+
+// App data transports
+const (
+	// TransportAuto uses run-as for debuggable apps and root otherwise
+	TransportAuto AppDataTransport = iota
+	TransportRunAs
+	TransportRoot
+	// TransportBackup uses the backup manager local transport. Its snapshots
+	// hold the restore set token, so they restore only on the device that took them
+	TransportBackup
+)
+
+// AppSnapshot is app data saved in a host file
+type AppSnapshot struct {
+	Package   string
+	Transport AppDataTransport
+	// Path is the tar of the app data directory, or the restore set token for TransportBackup.
+	// Tars restore with the transport that took them, so they can be shared across devices
+	// that allow it
+	Path string
+}
+
+// appDataScript archives the data directory, leaving out caches and the native libraries link
+const appDataScript = "tar -cf - $(ls -A | grep -v -x -e lib -e cache -e code_cache)"
+
+var (
+	restoreSetToken  = regexp.MustCompile(`(?m)^\s*([0-9a-f]+) : `)
+	currentTransport = regexp.MustCompile(`(?m)^\s*\*\s*(\S+)`)
+)
+
+// SnapshotAppData stops the app and saves its data into the path host file
+func (device *Device) SnapshotAppData(pkg, path string, transport AppDataTransport) (*AppSnapshot, error) {
+	transport = device.appDataTransport(pkg, transport)
+	snapshot := &AppSnapshot{Package: pkg, Transport: transport, Path: path}
+	if device.Log {
+		log.Printf("saving %s data into %s", pkg, path)
+	}
+	device.CloseApp(pkg)
+	service := device.binaryShell()
+	script := appDataScript
+	if service == "exec-out" {
+		// exec-out mixes the tar warnings into the archive
+		script += " 2>/dev/null"
+	}
+	switch transport {
+	case TransportRunAs:
+		if service == "exec-out" {
+			// without an exit status the run-as failure would be saved as the tar
+			if _, err := device.RunAs(pkg, "true"); err != nil {
+				return nil, err
+			}
+		}
+		if err := device.capture(path, service, fmt.Sprintf("run-as %s sh -c '%s'", pkg, script)); err != nil {
+			return nil, err
+		}
+		return snapshot, nil
+	case TransportRoot:
+		if err := device.rootShell(); err != nil {
+			return nil, err
+		}
+		if err := device.capture(path, service, fmt.Sprintf("sh -c 'cd /data/data/%s && %s'", pkg, script)); err != nil {
+			return nil, err
+		}
+		return snapshot, nil
+	case TransportBackup:
+		token, err := device.backupNow(pkg)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(token+"\n"), 0644); err != nil {
+			return nil, fmt.Errorf("os.WriteFile err: %v", err)
+		}
+		return snapshot, nil
+	}
+	return nil, fmt.Errorf("invalid app data transport %d", transport)
+}
+
+// RestoreAppData replaces the app data with the snapshot,
+// using the transport that took it.
+//
+// The current data is cleared first, which also stops the app
+// and revokes its runtime permissions
+func (device *Device) RestoreAppData(pkg string, snapshot *AppSnapshot) error {
+	if len(snapshot.Package) > 0 && snapshot.Package != pkg {
+		return fmt.Errorf("invalid snapshot; holds %s data, not %s", snapshot.Package, pkg)
+	}
+	if device.Log {
+		log.Printf("restoring %s data from %s", pkg, snapshot.Path)
+	}
+	switch snapshot.Transport {
+	case TransportRunAs:
+		if err := device.ClearApp(pkg); err != nil {
+			return err
+		}
+		return device.extract(snapshot.Path, fmt.Sprintf("run-as %s tar -xf -", pkg))
+	case TransportRoot:
+		if err := device.rootShell(); err != nil {
+			return err
+		}
+		if err := device.ClearApp(pkg); err != nil {
+			return err
+		}
+		dir := "/data/data/" + pkg
+		if err := device.extract(snapshot.Path, "tar -xf - -C "+dir); err != nil {
+			return err
+		}
+		// the extracted files belong to root until handed back to the app user
+		uid := cleanString(device.Shell("adb shell stat -c %u " + dir))
+		output := device.Shell(fmt.Sprintf("adb shell find %s -mindepth 1 -maxdepth 1 ! -name lib -exec chown -R %s:%s {} +", dir, uid, uid))
+		if len(strings.TrimSpace(output)) > 0 {
+			return fmt.Errorf("Failed to restore %s data ownership; output: %s", pkg, output)
+		}
+		device.Shell("adb shell restorecon -R " + dir)
+		return nil
+	case TransportBackup:
+		content, err := os.ReadFile(snapshot.Path)
+		if err != nil {
+			return fmt.Errorf("os.ReadFile err: %v", err)
+		}
+		output := device.Shell(fmt.Sprintf("adb shell bmgr restore %s %s", strings.TrimSpace(string(content)), pkg))
+		if !strings.Contains(output, "restoreFinished: 0") {
+			return fmt.Errorf("Failed to restore %s backup; output: %s", pkg, output)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid snapshot transport %d; restore needs the transport that took it", snapshot.Transport)
+}
+
+// appDataTransport picks run-as for debuggable apps and root otherwise
+func (device *Device) appDataTransport(pkg string, transport AppDataTransport) AppDataTransport {
+	if transport != TransportAuto {
+		return transport
+	}
+	if _, err := device.RunAs(pkg, "true"); err == nil {
+		return TransportRunAs
+	}
+	return TransportRoot
+}
+
+// rootShell restarts adbd as root and waits for the device to come back
+func (device *Device) rootShell() error {
+	if err := device.Root(); err != nil {
+		return err
+	}
+	device.Shell("adb wait-for-device")
+	if id := device.Shell("adb shell id -u"); cleanString(id) != "0" {
+		return fmt.Errorf("root is unavailable; adb shell runs as uid %s", id)
+	}
+	return nil
+}
+
+// extract streams the local tar into the given device command
+func (device *Device) extract(path, command string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("os.Open err: %v", err)
+	}
+	defer file.Close()
+	cmd := device.command(context.Background(), "shell", command)
+	cmd.Stdin = file
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to extract %s: %v; output: %s", path, err, output)
+	}
+	return nil
+}
+
+// backupNow backs the package up with the local transport and returns the restore set token.
+// The backup manager state and transport are put back afterwards
+func (device *Device) backupNow(pkg string) (string, error) {
+	if strings.Contains(device.Shell("adb shell bmgr enabled"), "disabled") {
+		defer device.Shell("adb shell bmgr enable false")
+	}
+	if previous := parseCurrentTransport(device.Shell("adb shell bmgr list transports")); len(previous) > 0 {
+		defer device.Shell("adb shell bmgr transport " + previous)
+	}
+	device.Shell("adb shell bmgr enable true")
+	device.Shell("adb shell bmgr transport com.android.localtransport/.LocalTransport")
+	output := device.Shell("adb shell bmgr backupnow " + pkg)
+	if !strings.Contains(output, "Success") {
+		return "", fmt.Errorf("Failed to back %s up; output: %s", pkg, output)
+	}
+	return parseRestoreSetToken(device.Shell("adb shell bmgr list sets"))
+}
+
+func parseRestoreSetToken(output string) (string, error) {
+	matches := restoreSetToken.FindStringSubmatch(output)
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no restore set available; output: %s", output)
+	}
+	return matches[1], nil
+}
+
+// parseCurrentTransport returns the transport marked with * in bmgr list transports
+func parseCurrentTransport(output string) string {
+	matches := currentTransport.FindStringSubmatch(output)
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[1]
+}
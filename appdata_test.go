@@ -0,0 +1,128 @@
+package adbtools
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRestoreSetToken(t *testing.T) {
+	token, err := parseRestoreSetToken("  1 : LocalTransport\n")
+	if err != nil || token != "1" {
+		t.Errorf("parseRestoreSetToken = %s, %v; want 1", token, err)
+	}
+	token, err = parseRestoreSetToken("  3a9f0c21b7d4e8f5 : Pixel 4\n  1 : LocalTransport\n")
+	if err != nil || token != "3a9f0c21b7d4e8f5" {
+		t.Errorf("parseRestoreSetToken = %s, %v; want 3a9f0c21b7d4e8f5", token, err)
+	}
+	if _, err := parseRestoreSetToken("No restore sets available\n"); err == nil {
+		t.Error("parseRestoreSetToken accepted an empty list")
+	}
+}
+
+func TestParseCurrentTransport(t *testing.T) {
+	output := `    android/com.android.internal.backup.LocalTransport
+  * com.google.android.gms/.backup.BackupTransportService
+    com.google.android.gms/.backup.migrate.service.D2dTransport
+`
+	if got, want := parseCurrentTransport(output), "com.google.android.gms/.backup.BackupTransportService"; got != want {
+		t.Errorf("parseCurrentTransport = %s; want %s", got, want)
+	}
+	if got := parseCurrentTransport("Backup Manager currently disabled\n"); got != "" {
+		t.Errorf("parseCurrentTransport = %s; want none", got)
+	}
+}
+
+func TestBackupNowRestoresTransport(t *testing.T) {
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeADB(t, `echo "$*" >> `+calls+`
+case "$*" in
+"shell bmgr enabled") echo "Backup Manager currently disabled";;
+"shell bmgr list transports") printf '  * com.google.android.gms/.backup.BackupTransportService\n    com.android.localtransport/.LocalTransport\n';;
+"shell bmgr backupnow com.example.app") echo "Package com.example.app with result: Success";;
+"shell bmgr list sets") echo "  1 : LocalTransport";;
+esac`)
+	device := &Device{}
+	if token, err := device.backupNow("com.example.app"); err != nil || token != "1" {
+		t.Fatalf("backupNow = %s, %v; want 1", token, err)
+	}
+	content, _ := os.ReadFile(calls)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	want := []string{
+		"shell bmgr transport com.google.android.gms/.backup.BackupTransportService",
+		"shell bmgr enable false",
+	}
+	if got := lines[len(lines)-2:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("backupNow ended with %q; want %q", got, want)
+	}
+}
+
+func TestRestoreAppDataTransport(t *testing.T) {
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeADB(t, `echo "$*" >> `+calls+`
+case "$*" in
+"shell pm clear com.example.app") echo Success;;
+esac`)
+	path := filepath.Join(t.TempDir(), "app.tar")
+	if err := os.WriteFile(path, []byte("tar"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	device := &Device{}
+	snapshot := &AppSnapshot{Package: "com.example.app", Transport: TransportRunAs, Path: path}
+	if err := device.RestoreAppData("com.example.app", snapshot); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(calls)
+	if got := string(content); strings.Contains(got, "root") || !strings.Contains(got, "shell run-as com.example.app tar -xf -") {
+		t.Errorf("the run-as snapshot restored with:\n%s", got)
+	}
+	snapshot.Transport = TransportAuto
+	if err := device.RestoreAppData("com.example.app", snapshot); err == nil {
+		t.Error("RestoreAppData accepted a snapshot without its transport")
+	}
+}
+
+func TestRestoreAppDataPackage(t *testing.T) {
+	device := &Device{}
+	snapshot := &AppSnapshot{Package: "com.example.app", Transport: TransportRunAs, Path: "app.tar"}
+	if err := device.RestoreAppData("com.example.other", snapshot); err == nil {
+		t.Error("RestoreAppData accepted a snapshot of another package")
+	}
+}
+
+func TestSnapshotAppDataFailure(t *testing.T) {
+	fakeADB(t, `case "$*" in
+features) echo shell_v2;;
+*run-as*) echo "run-as: package not debuggable: com.example.app" >&2; exit 1;;
+esac`)
+	path := filepath.Join(t.TempDir(), "app.tar")
+	device := &Device{}
+	snapshot, err := device.SnapshotAppData("com.example.app", path, TransportRunAs)
+	if err == nil || snapshot != nil {
+		t.Fatalf("SnapshotAppData = %v, %v; want a nil snapshot and an error", snapshot, err)
+	}
+	if !strings.Contains(err.Error(), "not debuggable") {
+		t.Errorf("SnapshotAppData err = %v; want the run-as message", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the failed snapshot left %s behind", path)
+	}
+}
+
+func TestSnapshotAppDataWithoutShellV2(t *testing.T) {
+	// neither exec-out nor the old adb shell report the exit status
+	fakeADB(t, `case "$*" in
+"shell run-as com.example.app true") echo "run-as: package not debuggable: com.example.app";;
+exec-out*) echo "run-as: package not debuggable: com.example.app";;
+esac`)
+	path := filepath.Join(t.TempDir(), "app.tar")
+	device := &Device{}
+	if snapshot, err := device.SnapshotAppData("com.example.app", path, TransportRunAs); err == nil {
+		t.Fatalf("SnapshotAppData = %v; want the run-as error", snapshot)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the run-as error was saved into %s", path)
+	}
+}
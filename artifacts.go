@@ -0,0 +1,165 @@
+package adbtools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArtifactOptions holds the optional arguments of CollectArtifacts
+type ArtifactOptions struct {
+	// LogcatLines is the amount of recent logcat lines; defaults to 5000
+	LogcatLines int
+	// Bugreport also captures a bugreport zip, which takes a few minutes
+	Bugreport bool
+}
+
+// Manifest describes a collected artifact bundle
+type Manifest struct {
+	DeviceID  string     `json:"device_id"`
+	Collected time.Time  `json:"collected"`
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// Artifact is a file of the bundle, or the reason it is missing
+type Artifact struct {
+	Name  string `json:"name"`
+	File  string `json:"file,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// TestingT is the part of testing.T used by CollectOnFailure
+type TestingT interface {
+	Cleanup(func())
+	Failed() bool
+	Logf(format string, args ...interface{})
+}
+
+// CollectArtifacts captures everything needed to debug a failure
+// into a new timestamped directory inside dir, and returns its path.
+//
+// A failed capture doesn't stop the others; it is recorded in manifest.json
+func (device *Device) CollectArtifacts(dir string, opts ArtifactOptions) (string, error) {
+	if device.Log {
+		log.Printf("collecting artifacts into %s", dir)
+	}
+	now := time.Now()
+	name := now.Format("20060102-150405.000")
+	if len(device.ID) > 0 {
+		name += "-" + regexp.MustCompile(`[^\w.-]`).ReplaceAllString(device.ID, "_")
+	}
+	bundle := filepath.Join(dir, name)
+	if err := os.MkdirAll(bundle, 0755); err != nil {
+		return "", fmt.Errorf("os.MkdirAll err: %v", err)
+	}
+	manifest := Manifest{DeviceID: device.ID, Collected: now}
+	add := func(name, file string, err error) {
+		artifact := Artifact{Name: name, File: file}
+		if err != nil {
+			log.Printf("failed to collect %s: %v", name, err)
+			artifact = Artifact{Name: name, Error: err.Error()}
+		}
+		manifest.Artifacts = append(manifest.Artifacts, artifact)
+	}
+	write := func(file, content string) error {
+		return os.WriteFile(filepath.Join(bundle, file), []byte(content), 0644)
+	}
+
+	flag, err := device.screencapFlag()
+	if err == nil {
+		err = device.capture(filepath.Join(bundle, "screenshot.png"), device.binaryShell(), "screencap -p"+flag)
+	}
+	add("screenshot", "screenshot.png", err)
+
+	screen, err := device.dumpScreen(true)
+	if err == nil {
+		err = write("window_dump.xml", screen)
+	}
+	add("ui hierarchy", "window_dump.xml", err)
+
+	lines := opts.LogcatLines
+	if lines <= 0 {
+		lines = 5000
+	}
+	add("logcat", "logcat.txt", device.capture(filepath.Join(bundle, "logcat.txt"), "logcat", "-d", "-v", "threadtime", "-t", strconv.Itoa(lines)))
+	add("top activity", "activity_top.txt", device.capture(filepath.Join(bundle, "activity_top.txt"), "shell", "dumpsys activity top"))
+	add("foreground window", "foreground.txt", device.capture(filepath.Join(bundle, "foreground.txt"), "shell", "dumpsys window windows | grep Focus"))
+	add("properties", "getprop.txt", device.capture(filepath.Join(bundle, "getprop.txt"), "shell", "getprop"))
+	if opts.Bugreport {
+		add("bugreport", "bugreport.zip", device.Bugreport(context.Background(), filepath.Join(bundle, "bugreport.zip"), nil))
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return bundle, fmt.Errorf("json.MarshalIndent err: %v", err)
+	}
+	if err := write("manifest.json", string(content)); err != nil {
+		return bundle, fmt.Errorf("os.WriteFile err: %v", err)
+	}
+	return bundle, nil
+}
+
+// CollectOnFailure collects the artifacts when the test ends, if it failed.
+// It is meant to be called at the beginning of the test:
+//
+//	device.CollectOnFailure(t, "artifacts", ArtifactOptions{})
+func (device *Device) CollectOnFailure(t TestingT, dir string, opts ArtifactOptions) {
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+		bundle, err := device.CollectArtifacts(dir, opts)
+		if err != nil {
+			t.Logf("CollectArtifacts err: %v", err)
+			return
+		}
+		t.Logf("failure artifacts saved at %s", bundle)
+	})
+}
+
+// capture writes the output of an adb command into the given host file;
+// the file is removed when the command fails.
+//
+// Only adb shell reports the remote exit status and keeps stderr apart;
+// binary output goes through binaryShell
+func (device *Device) capture(path string, args ...string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("os.Create err: %v", err)
+	}
+	stderr := &bytes.Buffer{}
+	cmd := device.command(context.Background(), args...)
+	cmd.Stdout = file
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	file.Close()
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("adb %s err: %v; output: %s", strings.Join(args, " "), err, stderr)
+	}
+	return nil
+}
+
+// binaryShell returns the adb service that keeps the output bytes intact.
+// Devices with the shell_v2 feature run adb shell without a pty, reporting
+// the exit status; older ones turn LF into CRLF there, so they use exec-out,
+// whose failures show only in the output
+func (device *Device) binaryShell() string {
+	output, err := device.command(context.Background(), "features").Output()
+	if err == nil {
+		for _, feature := range strings.Fields(string(output)) {
+			if feature == "shell_v2" {
+				return "shell"
+			}
+		}
+	}
+	return "exec-out"
+}
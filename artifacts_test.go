@@ -0,0 +1,127 @@
+package adbtools
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCollectArtifacts(t *testing.T) {
+	fakeADB(t, `case "$*" in
+*"screencap -p"*) printf 'PNG';;
+*"uiautomator dump"*) echo "UI hierchary dumped to: /sdcard/window_dump.xml";;
+*"cat /sdcard/window_dump.xml"*) echo "<hierarchy/>";;
+*logcat*) echo "10-18 12:00:00.000  1000  1000 I ActivityManager: Start proc";;
+*"dumpsys activity top"*) echo "cmd: Can't find service: activity" >&2; exit 1;;
+*"dumpsys window"*) echo "  mCurrentFocus=Window{1 u0 com.example.app/.MainActivity}";;
+*getprop*) echo "[ro.product.model]: [Pixel 4]";;
+esac`)
+	device := &Device{ID: "emulator-5554"}
+	bundle, err := device.CollectArtifacts(t.TempDir(), ArtifactOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(bundle, "-emulator-5554") {
+		t.Errorf("bundle %s is not named after the device", bundle)
+	}
+	content, err := os.ReadFile(filepath.Join(bundle, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := Manifest{}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.DeviceID != "emulator-5554" {
+		t.Errorf("manifest device = %s", manifest.DeviceID)
+	}
+
+	files := map[string]string{}
+	failures := map[string]string{}
+	for _, artifact := range manifest.Artifacts {
+		if len(artifact.Error) > 0 {
+			failures[artifact.Name] = artifact.Error
+			continue
+		}
+		files[artifact.Name] = artifact.File
+	}
+	want := map[string]string{
+		"screenshot":        "screenshot.png",
+		"ui hierarchy":      "window_dump.xml",
+		"logcat":            "logcat.txt",
+		"foreground window": "foreground.txt",
+		"properties":        "getprop.txt",
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("manifest files = %v; want %v", files, want)
+	}
+	for _, file := range want {
+		if _, err := os.Stat(filepath.Join(bundle, file)); err != nil {
+			t.Errorf("missing %s: %v", file, err)
+		}
+	}
+	if !strings.Contains(failures["top activity"], "Can't find service") || len(failures) != 1 {
+		t.Errorf("manifest failures = %v; want the top activity error", failures)
+	}
+	if _, err := os.Stat(filepath.Join(bundle, "activity_top.txt")); !os.IsNotExist(err) {
+		t.Error("the failed top activity capture was saved as an artifact")
+	}
+}
+
+type fakeT struct {
+	failed   bool
+	cleanups []func()
+	logs     []string
+}
+
+func (t *fakeT) Cleanup(fn func()) { t.cleanups = append(t.cleanups, fn) }
+
+func (t *fakeT) Failed() bool { return t.failed }
+
+func (t *fakeT) Logf(format string, args ...interface{}) { t.logs = append(t.logs, format) }
+
+func TestCollectOnFailure(t *testing.T) {
+	fakeADB(t, "")
+	device := &Device{}
+	for _, failed := range []bool{false, true} {
+		dir := t.TempDir()
+		test := &fakeT{failed: failed}
+		device.CollectOnFailure(test, dir, ArtifactOptions{})
+		if len(test.cleanups) != 1 {
+			t.Fatalf("CollectOnFailure registered %d cleanups; want 1", len(test.cleanups))
+		}
+		test.cleanups[0]()
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failed && len(entries) != 1 {
+			t.Errorf("failed test collected %d bundles; want 1", len(entries))
+		}
+		if !failed && len(entries) != 0 {
+			t.Errorf("passed test collected %d bundles; want none", len(entries))
+		}
+	}
+}
+
+func TestBinaryShell(t *testing.T) {
+	tests := []struct {
+		features string
+		want     string
+	}{
+		{"fixed_push_mkdir\nshell_v2\ncmd\nstat_v2", "shell"},
+		// before Android 7 the shell pty turns LF into CRLF
+		{"fixed_push_mkdir", "exec-out"},
+		{"error: device offline", "exec-out"},
+	}
+	for _, test := range tests {
+		fakeADB(t, fmt.Sprintf("[ \"$1\" = features ] && cat <<'EOF'\n%s\nEOF", test.features))
+		if got := (&Device{}).binaryShell(); got != test.want {
+			t.Errorf("binaryShell with features %q = %s; want %s", test.features, got, test.want)
+		}
+	}
+}
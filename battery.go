@@ -0,0 +1,170 @@
+package adbtools
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// BatteryInfo holds the dumpsys battery state
+type BatteryInfo struct {
+	Level int
+	Scale int
+	// Status is one of unknown, charging, discharging, not charging or full
+	Status string
+	// Health is one of unknown, good, overheat, dead, over voltage, failure or cold
+	Health string
+	// Plugged is one of ac, usb, wireless, dock or none
+	Plugged string
+	// Temperature in Celsius degrees
+	Temperature float64
+	// Voltage in millivolts
+	Voltage    int
+	Present    bool
+	Technology string
+}
+
+// App standby buckets
+const (
+	BucketActive     = "active"
+	BucketWorkingSet = "working_set"
+	BucketFrequent   = "frequent"
+	BucketRare       = "rare"
+	BucketRestricted = "restricted"
+)
+
+var (
+	batteryStatus = map[string]string{"1": "unknown", "2": "charging", "3": "discharging", "4": "not charging", "5": "full"}
+	batteryHealth = map[string]string{"1": "unknown", "2": "good", "3": "overheat", "4": "dead", "5": "over voltage", "6": "failure", "7": "cold"}
+)
+
+// Battery parses the dumpsys battery state
+func (device *Device) Battery() (BatteryInfo, error) {
+	output := device.Shell("adb shell dumpsys battery")
+	info, ok := parseBattery(output)
+	if !ok {
+		return info, fmt.Errorf("Failed to fetch battery state; output: %s", output)
+	}
+	return info, nil
+}
+
+// UnplugBattery simulates the device running on battery.
+//
+// Returns a function to be deferred resetting the battery to its real state
+func (device *Device) UnplugBattery() (func(), error) {
+	if device.Log {
+		log.Println("unplugging battery")
+	}
+	return device.batterySimulation("adb shell dumpsys battery unplug")
+}
+
+// SetBatteryLevel simulates the given battery level, such as 5 for low battery tests.
+//
+// Returns a function to be deferred resetting the battery to its real state
+func (device *Device) SetBatteryLevel(level int) (func(), error) {
+	if level < 0 || level > 100 {
+		return func() {}, fmt.Errorf("invalid battery level %d; must be between 0 and 100", level)
+	}
+	if device.Log {
+		log.Printf("setting battery level to %d%%", level)
+	}
+	return device.batterySimulation(fmt.Sprintf("adb shell dumpsys battery set level %d", level))
+}
+
+// ForceIdle forces the device into Doze.
+// The battery is unplugged first, since Doze only happens on battery.
+//
+// Returns a function to be deferred leaving Doze and resetting the battery
+func (device *Device) ForceIdle() (func(), error) {
+	resetBattery, err := device.UnplugBattery()
+	if err != nil {
+		return func() {}, err
+	}
+	if device.Log {
+		log.Println("forcing doze")
+	}
+	output := device.Shell("adb shell dumpsys deviceidle force-idle")
+	if !strings.Contains(output, "Now forced in to") {
+		resetBattery()
+		return func() {}, fmt.Errorf("Failed to force idle; output: %s", output)
+	}
+	return func() {
+		if device.Log {
+			log.Println("leaving doze")
+		}
+		device.Shell("adb shell dumpsys deviceidle unforce")
+		resetBattery()
+	}, nil
+}
+
+// SetStandbyBucket sets the app standby bucket of the package,
+// such as BucketRare.
+//
+// Returns a function to be deferred setting the previous bucket back
+func (device *Device) SetStandbyBucket(pkg, bucket string) (func(), error) {
+	current := cleanString(device.Shell("adb shell am get-standby-bucket " + pkg))
+	if _, err := strconv.Atoi(current); err != nil {
+		return func() {}, fmt.Errorf("Failed to fetch %s standby bucket; output: %s", pkg, current)
+	}
+	if device.Log {
+		log.Printf("setting %s standby bucket to %s", pkg, bucket)
+	}
+	output := strings.TrimSpace(device.Shell(fmt.Sprintf("adb shell am set-standby-bucket %s %s", pkg, bucket)))
+	if len(output) > 0 {
+		return func() {}, fmt.Errorf("Failed to set %s standby bucket; output: %s", pkg, output)
+	}
+	return func() {
+		if device.Log {
+			log.Printf("setting %s standby bucket back to %s", pkg, current)
+		}
+		output := strings.TrimSpace(device.Shell(fmt.Sprintf("adb shell am set-standby-bucket %s %s", pkg, current)))
+		if len(output) > 0 {
+			log.Printf("Failed to set %s standby bucket: %s", pkg, output)
+		}
+	}, nil
+}
+
+func (device *Device) batterySimulation(cmd string) (func(), error) {
+	output := strings.TrimSpace(device.Shell(cmd))
+	if len(output) > 0 {
+		return func() {}, fmt.Errorf("Failed to simulate battery state; output: %s", output)
+	}
+	return func() {
+		if device.Log {
+			log.Println("resetting battery state")
+		}
+		device.Shell("adb shell dumpsys battery reset")
+	}, nil
+}
+
+func parseBattery(output string) (BatteryInfo, bool) {
+	values := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), ": ", 2)
+		if len(kv) == 2 {
+			values[kv[0]] = strings.TrimSpace(kv[1])
+		}
+	}
+	if _, ok := values["level"]; !ok {
+		return BatteryInfo{}, false
+	}
+	info := BatteryInfo{
+		Status:     batteryStatus[values["status"]],
+		Health:     batteryHealth[values["health"]],
+		Plugged:    "none",
+		Present:    values["present"] == "true",
+		Technology: values["technology"],
+	}
+	info.Level, _ = strconv.Atoi(values["level"])
+	info.Scale, _ = strconv.Atoi(values["scale"])
+	info.Voltage, _ = strconv.Atoi(values["voltage"])
+	temperature, _ := strconv.Atoi(values["temperature"])
+	info.Temperature = float64(temperature) / 10
+	for key, plugged := range map[string]string{"AC powered": "ac", "USB powered": "usb", "Wireless powered": "wireless", "Dock powered": "dock"} {
+		if values[key] == "true" {
+			info.Plugged = plugged
+		}
+	}
+	return info, true
+}
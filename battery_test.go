@@ -0,0 +1,28 @@
+package adbtools
+
+import "testing"
+
+func TestParseBattery(t *testing.T) {
+	info, ok := parseBattery(`Current Battery Service state:
+  (UPDATES STOPPED -- use 'reset' to restart)
+  AC powered: false
+  USB powered: true
+  Wireless powered: false
+  Max charging current: 500000
+  status: 2
+  health: 2
+  present: true
+  level: 87
+  scale: 100
+  voltage: 4215
+  temperature: 253
+  technology: Li-ion
+`)
+	want := BatteryInfo{Level: 87, Scale: 100, Status: "charging", Health: "good", Plugged: "usb", Temperature: 25.3, Voltage: 4215, Present: true, Technology: "Li-ion"}
+	if !ok || info != want {
+		t.Errorf("unexpected battery:\n got: %+v\nwant: %+v", info, want)
+	}
+	if _, ok := parseBattery("Can't find service: battery"); ok {
+		t.Error("expected missing battery service to fail")
+	}
+}
@@ -0,0 +1,248 @@
+package adbtools
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BugreportInfo holds the key sections of a bugreport
+type BugreportInfo struct {
+	// Name is the bugreport text file name
+	Name       string
+	Properties map[string]string
+	Battery    string
+	MemInfo    string
+	// ANRTraces lists the ANR trace files, either bundled in the zip or listed by the report
+	ANRTraces []string
+	Logcat    string
+}
+
+var (
+	bugreportProgress = regexp.MustCompile(`^PROGRESS:(\d+)/(\d+)`)
+	propLine          = regexp.MustCompile(`^\[(.+?)\]: \[(.*)\]$`)
+)
+
+// Bugreport captures a zipped bugreport into the host path.
+//
+// progress is optional and receives the completion percentage;
+// devices without bugreportz fall back to adb bugreport, without progress.
+// Canceling the context stops the capture
+func (device *Device) Bugreport(ctx context.Context, path string, progress func(percent int)) error {
+	if device.Log {
+		log.Printf("capturing bugreport into %s", path)
+	}
+	if !strings.HasPrefix(device.Shell("adb shell bugreportz -v 2>&1"), "bugreportz") {
+		if device.Log {
+			log.Println("bugreportz not supported; using adb bugreport")
+		}
+		// adb bugreport prints its progress and saves the zip itself
+		if output, err := device.command(ctx, "bugreport", path).CombinedOutput(); err != nil {
+			return fmt.Errorf("adb bugreport err: %v; output: %s", err, output)
+		}
+		return nil
+	}
+
+	cmd := device.command(ctx, "shell", "bugreportz", "-p")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("cmd.StdoutPipe err: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("cmd.Start err: %v", err)
+	}
+	remote := ""
+	failure := ""
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case bugreportProgress.MatchString(line):
+			matches := bugreportProgress.FindStringSubmatch(line)
+			done, _ := strconv.Atoi(matches[1])
+			total, _ := strconv.Atoi(matches[2])
+			if progress != nil && total > 0 {
+				progress(done * 100 / total)
+			}
+		case strings.HasPrefix(line, "OK:"):
+			remote = strings.TrimPrefix(line, "OK:")
+		case strings.HasPrefix(line, "FAIL:"):
+			failure = strings.TrimPrefix(line, "FAIL:")
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("bugreportz err: %v", err)
+	}
+	if len(failure) > 0 {
+		return fmt.Errorf("Failed to capture bugreport: %s", failure)
+	}
+	if len(remote) == 0 {
+		return fmt.Errorf("Failed to capture bugreport; zip path not found")
+	}
+	if progress != nil {
+		progress(100)
+	}
+	if err := device.Pull(remote, path); err != nil {
+		return fmt.Errorf("Failed to pull %s: %v", remote, err)
+	}
+	// the zips pile up in /bugreports until removed
+	if err := device.Remove(remote); err != nil {
+		log.Printf("bugreport cleanup err: %v", err)
+	}
+	return nil
+}
+
+// ParseBugreport extracts the key sections of a bugreport zip
+func ParseBugreport(zipPath string) (*BugreportInfo, error) {
+	archive, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("zip.OpenReader err: %v", err)
+	}
+	defer archive.Close()
+
+	files := map[string]*zip.File{}
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+	name := ""
+	if file, ok := files["main_entry.txt"]; ok {
+		content, err := readZipFile(file)
+		if err != nil {
+			return nil, err
+		}
+		name = strings.TrimSpace(content)
+	}
+	if _, ok := files[name]; !ok {
+		name = ""
+		for _, file := range archive.File {
+			if strings.HasPrefix(path.Base(file.Name), "bugreport") && strings.HasSuffix(file.Name, ".txt") {
+				name = file.Name
+				break
+			}
+		}
+	}
+	if len(name) == 0 {
+		return nil, fmt.Errorf("bugreport text file not found in %s", zipPath)
+	}
+	file, err := files[name].Open()
+	if err != nil {
+		return nil, fmt.Errorf("file.Open err: %v", err)
+	}
+	defer file.Close()
+
+	info, err := parseBugreportText(file)
+	if err != nil {
+		return nil, err
+	}
+	info.Name = name
+	bundled := []string{}
+	for _, file := range archive.File {
+		if strings.HasPrefix(file.Name, "FS/data/anr/") && !strings.HasSuffix(file.Name, "/") {
+			bundled = append(bundled, file.Name)
+		}
+	}
+	if len(bundled) > 0 {
+		info.ANRTraces = bundled
+	}
+	return info, nil
+}
+
+// parseBugreportText splits the bugreport text into its sections.
+//
+// Sections start with a "------ TITLE (command) ------" line,
+// and dumpsys services with a "DUMP OF SERVICE name:" line
+func parseBugreportText(r io.Reader) (*BugreportInfo, error) {
+	info := &BugreportInfo{Properties: map[string]string{}}
+	section := ""
+	sections := map[string]*strings.Builder{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "------ "):
+			section = ""
+			switch {
+			case strings.HasPrefix(line, "------ SYSTEM PROPERTIES"):
+				section = "properties"
+			case strings.HasPrefix(line, "------ MEMORY INFO"):
+				section = "meminfo"
+			case strings.HasPrefix(line, "------ ANR FILES"):
+				section = "anr"
+			case strings.HasPrefix(line, "------ SYSTEM LOG"):
+				section = "logcat"
+			}
+			continue
+		case strings.HasPrefix(line, "DUMP OF SERVICE "):
+			section = ""
+			if strings.TrimSpace(line) == "DUMP OF SERVICE battery:" {
+				section = "battery"
+			}
+			continue
+		case strings.HasPrefix(line, "--------") && !strings.HasPrefix(line, "--------- beginning of"):
+			// end of a dumpsys service or separator between sections;
+			// logcat buffer headers are kept
+			section = ""
+			continue
+		}
+		if len(section) == 0 {
+			continue
+		}
+		if sections[section] == nil {
+			sections[section] = &strings.Builder{}
+		}
+		sections[section].WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanner.Err: %v", err)
+	}
+	text := func(name string) string {
+		if sections[name] == nil {
+			return ""
+		}
+		return sections[name].String()
+	}
+	info.Properties = parseProps(text("properties"))
+	info.Battery = text("battery")
+	info.MemInfo = text("meminfo")
+	info.Logcat = text("logcat")
+	for _, line := range strings.Split(text("anr"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.HasPrefix(line, "-") {
+			info.ANRTraces = append(info.ANRTraces, "/data/anr/"+fields[len(fields)-1])
+		}
+	}
+	return info, nil
+}
+
+// parseProps parses the getprop "[key]: [value]" lines
+func parseProps(output string) map[string]string {
+	props := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		matches := propLine.FindStringSubmatch(strings.TrimSpace(line))
+		if len(matches) == 3 {
+			props[matches[1]] = matches[2]
+		}
+	}
+	return props
+}
+
+func readZipFile(file *zip.File) (string, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return "", fmt.Errorf("file.Open err: %v", err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("io.ReadAll err: %v", err)
+	}
+	return string(content), nil
+}
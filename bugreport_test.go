@@ -0,0 +1,145 @@
+package adbtools
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const bugreportText = `========================================================
+== dumpstate: 2026-10-18 17:20:01
+========================================================
+------ MEMORY INFO (/proc/meminfo) ------
+MemTotal:        2037180 kB
+MemFree:          153412 kB
+------ 0.001s was the duration of 'MEMORY INFO' ------
+------ SYSTEM LOG (logcat -v threadtime -v printable -v uid -d *:v) ------
+--------- beginning of main
+10-18 17:19:59.000  1000  1234  1234 I ActivityManager: Start proc
+------ 0.210s was the duration of 'SYSTEM LOG' ------
+------ ANR FILES (ls -lt /data/anr/) ------
+total 8
+-rw------- 1 system system 4096 2026-10-18 17:10 anr_2026-10-18-17-10-00-123
+------ 0.001s was the duration of 'ANR FILES' ------
+------ SYSTEM PROPERTIES (getprop) ------
+[ro.build.version.sdk]: [30]
+[ro.product.model]: [Pixel 4]
+------ 0.010s was the duration of 'SYSTEM PROPERTIES' ------
+-------------------------------------------------------------------------------
+DUMP OF SERVICE battery:
+Current Battery Service state:
+  level: 87
+--------- 0.002s was the duration of dumpsys battery, ending at: 2026-10-18 17:20:03
+-------------------------------------------------------------------------------
+DUMP OF SERVICE batteryproperties:
+--------- 0.001s was the duration of dumpsys batteryproperties
+`
+
+func TestParseBugreport(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "bugreport.zip")
+	file, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := zip.NewWriter(file)
+	for name, content := range map[string]string{
+		"main_entry.txt":                   "bugreport-sdk-2026-10-18.txt",
+		"bugreport-sdk-2026-10-18.txt":     bugreportText,
+		"FS/data/anr/anr_2026-10-18-17-10": "----- pid 4500 -----",
+	} {
+		writer, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write([]byte(content))
+	}
+	archive.Close()
+	file.Close()
+
+	info, err := ParseBugreport(zipPath)
+	if err != nil {
+		t.Fatalf("ParseBugreport err: %v", err)
+	}
+	if !reflect.DeepEqual(info.Properties, map[string]string{"ro.build.version.sdk": "30", "ro.product.model": "Pixel 4"}) {
+		t.Errorf("unexpected properties: %v", info.Properties)
+	}
+	if info.Battery != "Current Battery Service state:\n  level: 87\n" {
+		t.Errorf("unexpected battery section: %q", info.Battery)
+	}
+	if !strings.HasPrefix(info.MemInfo, "MemTotal:") || strings.Contains(info.MemInfo, "duration") {
+		t.Errorf("unexpected meminfo section: %q", info.MemInfo)
+	}
+	if !strings.HasPrefix(info.Logcat, "--------- beginning of main\n") || !strings.Contains(info.Logcat, "Start proc") {
+		t.Errorf("unexpected logcat section: %q", info.Logcat)
+	}
+	if !reflect.DeepEqual(info.ANRTraces, []string{"FS/data/anr/anr_2026-10-18-17-10"}) {
+		t.Errorf("unexpected anr traces: %v", info.ANRTraces)
+	}
+}
+
+func TestBugreportPull(t *testing.T) {
+	remote := "/bugreports/bugreport-sdk_gphone-2026-10-18-17-20-01.zip"
+	removed := filepath.Join(t.TempDir(), "removed")
+	fakeADB(t, `case "$*" in
+*"bugreportz -v"*) echo "bugreportz 1.2";;
+*"bugreportz -p"*) echo "PROGRESS:50/100"; echo "OK:`+remote+`";;
+*"rm -rf"*) echo "$*" > `+removed+`;;
+esac`)
+	startADBServer(t, map[string][]byte{remote: []byte("PK")})
+
+	percents := []int{}
+	path := filepath.Join(t.TempDir(), "bugreport.zip")
+	device := &Device{}
+	if err := device.Bugreport(context.Background(), path, func(percent int) { percents = append(percents, percent) }); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(path); err != nil || string(content) != "PK" {
+		t.Errorf("bugreport.zip = %q, %v", content, err)
+	}
+	if !reflect.DeepEqual(percents, []int{50, 100}) {
+		t.Errorf("progress = %v; want [50 100]", percents)
+	}
+	if command, _ := os.ReadFile(removed); !strings.Contains(string(command), remote) {
+		t.Errorf("the remote zip was not removed; rm command: %q", command)
+	}
+
+	// a zip that cannot be pulled fails the capture and stays on the device
+	os.Remove(removed)
+	startADBServer(t, map[string][]byte{})
+	if err := device.Bugreport(context.Background(), filepath.Join(t.TempDir(), "bugreport.zip"), nil); err == nil {
+		t.Error("Bugreport ignored the pull failure")
+	}
+	if _, err := os.Stat(removed); !os.IsNotExist(err) {
+		t.Error("the remote zip was removed after a failed pull")
+	}
+}
+
+func TestBugreportFallback(t *testing.T) {
+	// adb bugreport saves the zip into its argument and prints the progress
+	fakeADB(t, `case "$*" in
+*"bugreportz -v"*) echo "/system/bin/sh: bugreportz: not found";;
+bugreport*) printf PK > "$2"; echo "[ 50%] generating bugreport";;
+esac`)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bugreport.zip")
+	device := &Device{}
+	if err := device.Bugreport(context.Background(), path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(path); err != nil || string(content) != "PK" {
+		t.Errorf("bugreport.zip = %q, %v", content, err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("the fallback left %d files in %s; want only the zip", len(files), dir)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := device.Bugreport(ctx, filepath.Join(dir, "canceled.zip"), nil); err == nil {
+		t.Error("Bugreport ignored the canceled context")
+	}
+}
@@ -0,0 +1,189 @@
+package adbtools
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ContentValues holds typed column values to bind.
+//
+// Values are string, int, int32, int64, bool, float32, float64 or nil
+type ContentValues map[string]interface{}
+
+var (
+	contentRow    = regexp.MustCompile(`^Row: \d+ `)
+	contentColumn = regexp.MustCompile(`(?:^|, )([A-Za-z_][A-Za-z0-9_.]*)=`)
+)
+
+// ContentQuery queries a content provider, such as content://contacts/people.
+//
+// Projection, where and sort are optional; NULL values read as "NULL".
+// Without the projection the columns are guessed from the output, so a value
+// holding ", name=" splits into a made-up name column; pass the projection
+// when querying free text
+func (device *Device) ContentQuery(uri string, projection []string, where, sort string) ([]map[string]string, error) {
+	args := []string{"query", "--uri", uri}
+	if len(projection) > 0 {
+		args = append(args, "--projection", strings.Join(projection, ":"))
+	}
+	if len(where) > 0 {
+		args = append(args, "--where", where)
+	}
+	if len(sort) > 0 {
+		args = append(args, "--sort", sort)
+	}
+	output, err := device.content(args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseContentRows(output, projection), nil
+}
+
+// ContentInsert inserts a row into a content provider
+func (device *Device) ContentInsert(uri string, values ContentValues) error {
+	binds, err := contentBinds(values)
+	if err != nil {
+		return err
+	}
+	_, err = device.content(append([]string{"insert", "--uri", uri}, binds...)...)
+	return err
+}
+
+// ContentUpdate updates the rows of a content provider matching where, or all of them when empty
+func (device *Device) ContentUpdate(uri string, values ContentValues, where string) error {
+	binds, err := contentBinds(values)
+	if err != nil {
+		return err
+	}
+	args := append([]string{"update", "--uri", uri}, binds...)
+	if len(where) > 0 {
+		args = append(args, "--where", where)
+	}
+	_, err = device.content(args...)
+	return err
+}
+
+// ContentDelete deletes the rows of a content provider matching where, or all of them when empty
+func (device *Device) ContentDelete(uri, where string) error {
+	args := []string{"delete", "--uri", uri}
+	if len(where) > 0 {
+		args = append(args, "--where", where)
+	}
+	_, err := device.content(args...)
+	return err
+}
+
+// content runs the content tool, quoting every argument for the device shell
+func (device *Device) content(args ...string) (string, error) {
+	command := "content"
+	for _, arg := range args {
+		command += " " + shellQuote(arg)
+	}
+	output, err := device.command(context.Background(), "shell", command).CombinedOutput()
+	// query results may hold the word Exception; failures report it on the first line
+	firstLine := strings.SplitN(string(output), "\n", 2)[0]
+	if err != nil || strings.Contains(firstLine, "Error while accessing provider") || strings.Contains(firstLine, "Exception") {
+		return string(output), fmt.Errorf("Failed to run content %s: %v; output: %s", args[0], err, output)
+	}
+	return string(output), nil
+}
+
+// contentBinds converts the values into --bind column:type:value arguments
+func contentBinds(values ContentValues) ([]string, error) {
+	columns := []string{}
+	for column := range values {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	binds := []string{}
+	for _, column := range columns {
+		bind := ""
+		switch value := values[column].(type) {
+		case string:
+			bind = "s:" + value
+		case int, int32:
+			bind = fmt.Sprintf("i:%d", value)
+		case int64:
+			bind = fmt.Sprintf("l:%d", value)
+		case bool:
+			bind = fmt.Sprintf("b:%t", value)
+		case float32:
+			bind = fmt.Sprintf("f:%v", value)
+		case float64:
+			bind = fmt.Sprintf("d:%v", value)
+		case nil:
+			bind = "n:"
+		default:
+			return nil, fmt.Errorf("invalid %s value: unsupported type %T", column, value)
+		}
+		binds = append(binds, "--bind", column+":"+bind)
+	}
+	return binds, nil
+}
+
+// parseContentRows parses the Row: N column=value, ... lines of content query;
+// values may span several lines
+func parseContentRows(output string, projection []string) []map[string]string {
+	rows := []map[string]string{}
+	texts := []string{}
+	for _, line := range strings.Split(strings.TrimRight(output, "\r\n"), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if contentRow.MatchString(line) {
+			texts = append(texts, contentRow.ReplaceAllString(line, ""))
+		} else if len(texts) > 0 {
+			texts[len(texts)-1] += "\n" + line
+		}
+	}
+	for _, text := range texts {
+		if len(projection) > 0 {
+			rows = append(rows, splitProjectedRow(text, projection))
+		} else {
+			rows = append(rows, splitRow(text))
+		}
+	}
+	return rows
+}
+
+// splitProjectedRow splits the row at the known columns, in the projection order
+func splitProjectedRow(text string, projection []string) map[string]string {
+	row := map[string]string{}
+	start := 0
+	for i, column := range projection {
+		start += len(column) + 1
+		end := len(text)
+		if i+1 < len(projection) {
+			if next := strings.Index(text[start:], ", "+projection[i+1]+"="); next >= 0 {
+				end = start + next
+			}
+		}
+		if start > end {
+			break
+		}
+		row[column] = text[start:end]
+		start = end + 2
+	}
+	return row
+}
+
+// splitRow splits the row at every ", column=" it finds,
+// including the ones inside values, since the real columns are unknown
+func splitRow(text string) map[string]string {
+	row := map[string]string{}
+	matches := contentColumn.FindAllStringSubmatchIndex(text, -1)
+	for i, match := range matches {
+		end := len(text)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		row[text[match[2]:match[3]]] = text[match[1]:end]
+	}
+	return row
+}
+
+// shellQuote single quotes the argument for the device shell
+func shellQuote(arg string) string {
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,75 @@
+package adbtools
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseContentRows(t *testing.T) {
+	output := "Row: 0 _id=1, address=+5511999990000, body=Hi, see you at 5, ok? a=b, date=1792343401000\n" +
+		"Row: 1 _id=2, address=NULL, body=line one\nline two, date=1792343402000\n"
+
+	projection := []string{"_id", "address", "body", "date"}
+	want := []map[string]string{
+		{"_id": "1", "address": "+5511999990000", "body": "Hi, see you at 5, ok? a=b", "date": "1792343401000"},
+		{"_id": "2", "address": "NULL", "body": "line one\nline two", "date": "1792343402000"},
+	}
+	if got := parseContentRows(output, projection); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseContentRows(projection) =\n%q\nwant\n%q", got, want)
+	}
+
+	// without the projection only values holding ", column=" split wrong
+	got := parseContentRows("Row: 0 name=accelerometer_rotation, value=key=1, extra, more\n", nil)
+	want = []map[string]string{{"name": "accelerometer_rotation", "value": "key=1, extra, more"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseContentRows =\n%q\nwant\n%q", got, want)
+	}
+
+	// values holding ", column=" need the projection; without it they split
+	text := "Row: 0 _id=1, body=Moved, time=5pm, date=1792343401000\n"
+	got = parseContentRows(text, nil)
+	want = []map[string]string{{"_id": "1", "body": "Moved", "time": "5pm", "date": "1792343401000"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseContentRows =\n%q\nwant\n%q", got, want)
+	}
+	got = parseContentRows(text, []string{"_id", "body", "date"})
+	want = []map[string]string{{"_id": "1", "body": "Moved, time=5pm", "date": "1792343401000"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseContentRows(projection) =\n%q\nwant\n%q", got, want)
+	}
+
+	if rows := parseContentRows("No result found.\n", nil); len(rows) != 0 {
+		t.Errorf("parseContentRows(empty) = %v", rows)
+	}
+}
+
+func TestContentBinds(t *testing.T) {
+	binds, err := contentBinds(ContentValues{
+		"name":    "O'Brien",
+		"starred": true,
+		"times":   3,
+		"date":    int64(1792343401000),
+		"rating":  float32(4.5),
+		"photo":   nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"--bind", "date:l:1792343401000",
+		"--bind", "name:s:O'Brien",
+		"--bind", "photo:n:",
+		"--bind", "rating:f:4.5",
+		"--bind", "starred:b:true",
+		"--bind", "times:i:3",
+	}
+	if !reflect.DeepEqual(binds, want) {
+		t.Errorf("contentBinds = %q; want %q", binds, want)
+	}
+	if _, err := contentBinds(ContentValues{"data": []byte{1}}); err == nil {
+		t.Error("contentBinds accepted a []byte")
+	}
+	if got := shellQuote("O'Brien"); got != `'O'\''Brien'` {
+		t.Errorf("shellQuote = %s", got)
+	}
+}
@@ -0,0 +1,329 @@
+package adbtools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CrashKind tells how an app died
+type CrashKind int
+
+// Crash kinds
+const (
+	FatalException CrashKind = iota
+	NativeCrash
+	ANR
+)
+
+func (kind CrashKind) String() string {
+	switch kind {
+	case FatalException:
+		return "fatal exception"
+	case NativeCrash:
+		return "native crash"
+	case ANR:
+		return "ANR"
+	}
+	return "unknown crash"
+}
+
+// CrashEvent is a crash or ANR of a watched package
+type CrashEvent struct {
+	Kind CrashKind
+	// Package is the process name, as in com.example.app:remote for the secondary processes
+	Package string
+	PID     int
+	Time    time.Time
+	// Reason is the exception, signal or ANR reason line
+	Reason string
+	// Stack holds every reported line, including the stack trace or backtrace
+	Stack string
+}
+
+// CrashError is returned by the waits interrupted by a crash
+type CrashError struct {
+	Event CrashEvent
+}
+
+func (err *CrashError) Error() string {
+	return fmt.Sprintf("%s crashed with %s: %s", err.Event.Package, err.Event.Kind, err.Event.Reason)
+}
+
+// CrashWatcher monitors a package for crashes and ANRs
+type CrashWatcher struct {
+	pkg     string
+	events  chan CrashEvent
+	crashed chan struct{}
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	err     *CrashError
+}
+
+var (
+	crashProcess   = regexp.MustCompile(`^Process: ([\w.:]+), PID: (\d+)`)
+	nativeProcess  = regexp.MustCompile(`pid: (\d+), tid: \d+, name: .*>>> ([\w.:]+) <<<`)
+	nativeSignal   = regexp.MustCompile(`^signal \d+ \(\w+\)`)
+	anrProcess     = regexp.MustCompile(`^ANR in ([\w.:]+)`)
+	anrReason      = regexp.MustCompile(`^Reason: (.*)`)
+	processRecord  = regexp.MustCompile(`ProcessRecord\{\w+ (\d+):([\w.:]+)/`)
+	crashIdleDelay = 500 * time.Millisecond
+)
+
+// WatchCrashes starts monitoring the package for fatal exceptions,
+// native crashes and ANRs until the context is done or Stop is called.
+//
+// While watching, the device waits such as WaitInScreen and WaitApp
+// fail with a *CrashError as soon as the package crashes
+func (device *Device) WatchCrashes(ctx context.Context, pkg string) (*CrashWatcher, error) {
+	// older crashes must not count; logcat compares against the device clock
+	now, err := device.DeviceTime()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	entries, err := device.Logcat(ctx, LogcatOptions{
+		Buffers: []string{BufferMain, BufferSystem, BufferCrash},
+		Filters: []string{"AndroidRuntime:E", "DEBUG:F", "ActivityManager:E", "*:S"},
+		Since:   now,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	watcher := &CrashWatcher{
+		pkg:     pkg,
+		events:  make(chan CrashEvent, 16),
+		crashed: make(chan struct{}),
+		cancel:  cancel,
+	}
+	device.locked(func() { device.crashes = watcher })
+	if device.Log {
+		log.Printf("watching %s for crashes", pkg)
+	}
+
+	go func() {
+		defer close(watcher.events)
+		defer device.locked(func() {
+			if device.crashes == watcher {
+				device.crashes = nil
+			}
+		})
+		parser := crashParser{}
+		// the same crash may be found both in logcat and in dumpsys
+		seen := map[string]bool{}
+		report := func(event *CrashEvent) {
+			if event == nil {
+				return
+			}
+			key := fmt.Sprintf("%s/%d", event.Kind, event.PID)
+			if !seen[key] {
+				seen[key] = true
+				watcher.report(event)
+			}
+		}
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			var idle <-chan time.Time
+			if parser.current != nil {
+				idle = time.After(crashIdleDelay)
+			}
+			select {
+			case entry, ok := <-entries:
+				if !ok {
+					report(parser.flush())
+					return
+				}
+				for _, event := range parser.feed(entry) {
+					report(event)
+				}
+			case <-idle:
+				report(parser.flush())
+			case <-ticker.C:
+				for _, event := range device.processFailures(pkg) {
+					event := event
+					report(&event)
+				}
+			}
+		}
+	}()
+	return watcher, nil
+}
+
+// Events delivers every crash of the watched package
+func (watcher *CrashWatcher) Events() <-chan CrashEvent {
+	return watcher.events
+}
+
+// Err returns the first crash as a *CrashError, or nil
+func (watcher *CrashWatcher) Err() error {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	if watcher.err == nil {
+		return nil
+	}
+	return watcher.err
+}
+
+// Stop stops watching
+func (watcher *CrashWatcher) Stop() {
+	watcher.cancel()
+}
+
+func (watcher *CrashWatcher) report(event *CrashEvent) {
+	if len(watcher.pkg) > 0 && !inPackage(event.Package, watcher.pkg) {
+		return
+	}
+	log.Printf("%s %s: %s", event.Package, event.Kind, event.Reason)
+	watcher.mu.Lock()
+	if watcher.err == nil {
+		watcher.err = &CrashError{Event: *event}
+		close(watcher.crashed)
+	}
+	watcher.mu.Unlock()
+	select {
+	case watcher.events <- *event:
+	default:
+		log.Printf("crash events channel is full; dropping %s event", event.Kind)
+	}
+}
+
+// wait sleeps like sleep, returning early with a *CrashError
+// when the watched package crashes
+func (device *Device) wait(delay int) error {
+	var watcher *CrashWatcher
+	device.locked(func() { watcher = device.crashes })
+	if watcher == nil {
+		device.sleep(delay)
+		return nil
+	}
+	select {
+	case <-watcher.crashed:
+		return watcher.Err()
+	case <-time.After(time.Duration(device.defaultSleep()*delay) * time.Millisecond):
+		return nil
+	}
+}
+
+// processFailures reads the crashing and not responding flags
+// of the package processes from dumpsys activity
+func (device *Device) processFailures(pkg string) []CrashEvent {
+	return parseProcessFailures(device.Shell("adb shell dumpsys activity processes "+pkg), pkg)
+}
+
+func parseProcessFailures(output, pkg string) []CrashEvent {
+	events := []CrashEvent{}
+	var current *CrashEvent
+	for _, line := range strings.Split(output, "\n") {
+		if matches := processRecord.FindStringSubmatch(line); len(matches) > 0 {
+			current = nil
+			if inPackage(matches[2], pkg) {
+				pid, _ := strconv.Atoi(matches[1])
+				current = &CrashEvent{Package: matches[2], PID: pid, Time: time.Now()}
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if strings.Contains(line, "notResponding=true") {
+			events = append(events, CrashEvent{Kind: ANR, Package: current.Package, PID: current.PID, Time: current.Time, Reason: "process not responding"})
+			current = nil
+		} else if strings.Contains(line, "crashing=true") {
+			events = append(events, CrashEvent{Kind: FatalException, Package: current.Package, PID: current.PID, Time: current.Time, Reason: "process crashing"})
+			current = nil
+		}
+	}
+	return events
+}
+
+// inPackage verifies if the process is the package main process or one of its
+// secondary processes, which are named after the package and a colon
+func inPackage(process, pkg string) bool {
+	return process == pkg || strings.HasPrefix(process, pkg+":")
+}
+
+// crashParser assembles the multi-line crash reports,
+// which are logged one entry per line
+type crashParser struct {
+	current *CrashEvent
+	tag     string
+	pid     int
+	lines   []string
+}
+
+// feed handles an entry and returns the reports it completes
+func (parser *crashParser) feed(entry LogEntry) []*CrashEvent {
+	done := []*CrashEvent{}
+	start := func(kind CrashKind) {
+		if event := parser.flush(); event != nil {
+			done = append(done, event)
+		}
+		parser.current = &CrashEvent{Kind: kind, PID: entry.PID, Time: entry.Time}
+		parser.tag = entry.Tag
+		parser.pid = entry.PID
+	}
+	switch {
+	case entry.Tag == "AndroidRuntime" && strings.HasPrefix(entry.Message, "FATAL EXCEPTION"):
+		start(FatalException)
+	case entry.Tag == "DEBUG" && strings.HasPrefix(entry.Message, "*** *** ***"):
+		start(NativeCrash)
+	case entry.Tag == "ActivityManager" && anrProcess.MatchString(entry.Message):
+		start(ANR)
+	case parser.current == nil || entry.Tag != parser.tag || entry.PID != parser.pid:
+		if event := parser.flush(); event != nil {
+			done = append(done, event)
+		}
+		return done
+	}
+
+	event := parser.current
+	parser.lines = append(parser.lines, entry.Message)
+	message := strings.TrimSpace(entry.Message)
+	switch event.Kind {
+	case FatalException:
+		if matches := crashProcess.FindStringSubmatch(message); len(matches) > 0 {
+			event.Package = matches[1]
+			event.PID, _ = strconv.Atoi(matches[2])
+		} else if len(event.Package) > 0 && len(event.Reason) == 0 {
+			event.Reason = message
+		}
+	case NativeCrash:
+		if matches := nativeProcess.FindStringSubmatch(message); len(matches) > 0 {
+			event.PID, _ = strconv.Atoi(matches[1])
+			event.Package = matches[2]
+		} else if nativeSignal.MatchString(message) && len(event.Reason) == 0 {
+			event.Reason = message
+		}
+	case ANR:
+		if matches := anrProcess.FindStringSubmatch(message); len(matches) > 0 {
+			event.Package = matches[1]
+		} else if matches := anrReason.FindStringSubmatch(message); len(matches) > 0 {
+			event.Reason = matches[1]
+		} else if strings.HasPrefix(message, "PID: ") {
+			event.PID, _ = strconv.Atoi(strings.TrimPrefix(message, "PID: "))
+		}
+	}
+	return done
+}
+
+// flush returns the report being assembled, if any
+func (parser *crashParser) flush() *CrashEvent {
+	event := parser.current
+	if event == nil {
+		return nil
+	}
+	event.Stack = strings.Join(parser.lines, "\n")
+	if len(event.Reason) == 0 {
+		event.Reason = event.Kind.String()
+	}
+	parser.current = nil
+	parser.lines = nil
+	return event
+}
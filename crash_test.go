@@ -0,0 +1,83 @@
+package adbtools
+
+import (
+	"strings"
+	"testing"
+)
+
+func feedLines(parser *crashParser, lines ...string) []*CrashEvent {
+	events := []*CrashEvent{}
+	for _, line := range lines {
+		entry, ok := parseLogLine(line)
+		if !ok {
+			continue
+		}
+		events = append(events, parser.feed(entry)...)
+	}
+	if event := parser.flush(); event != nil {
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestCrashParser(t *testing.T) {
+	events := feedLines(&crashParser{},
+		"2026-10-18 17:20:01.000  4321  4321 E AndroidRuntime: FATAL EXCEPTION: main",
+		"2026-10-18 17:20:01.000  4321  4321 E AndroidRuntime: Process: com.example.app, PID: 4321",
+		"2026-10-18 17:20:01.000  4321  4321 E AndroidRuntime: java.lang.IllegalStateException: boom",
+		"2026-10-18 17:20:01.000  4321  4321 E AndroidRuntime: 	at com.example.app.MainActivity.onCreate(MainActivity.java:10)",
+		"2026-10-18 17:20:02.000  5000  5000 F DEBUG   : *** *** *** *** *** *** *** *** *** *** *** *** *** *** *** ***",
+		"2026-10-18 17:20:02.000  5000  5000 F DEBUG   : pid: 4400, tid: 4401, name: RenderThread  >>> com.example.app <<<",
+		"2026-10-18 17:20:02.000  5000  5000 F DEBUG   : signal 11 (SIGSEGV), code 1 (SEGV_MAPERR), fault addr 0x0",
+		"2026-10-18 17:20:02.000  5000  5000 F DEBUG   :     #00 pc 0000000000012345  /data/app/lib/arm64/libnative.so",
+		"2026-10-18 17:20:03.000  1000  1020 E ActivityManager: ANR in com.example.app (com.example.app/.MainActivity)",
+		"2026-10-18 17:20:03.000  1000  1020 E ActivityManager: PID: 4500",
+		"2026-10-18 17:20:03.000  1000  1020 E ActivityManager: Reason: Input dispatching timed out",
+	)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events; got %d: %+v", len(events), events)
+	}
+	fatal, native, anr := events[0], events[1], events[2]
+	if fatal.Kind != FatalException || fatal.Package != "com.example.app" || fatal.PID != 4321 ||
+		fatal.Reason != "java.lang.IllegalStateException: boom" || !strings.Contains(fatal.Stack, "MainActivity.java:10") {
+		t.Errorf("unexpected fatal exception: %+v", fatal)
+	}
+	if native.Kind != NativeCrash || native.Package != "com.example.app" || native.PID != 4400 ||
+		!strings.HasPrefix(native.Reason, "signal 11 (SIGSEGV)") || !strings.Contains(native.Stack, "libnative.so") {
+		t.Errorf("unexpected native crash: %+v", native)
+	}
+	if anr.Kind != ANR || anr.Package != "com.example.app" || anr.PID != 4500 || anr.Reason != "Input dispatching timed out" {
+		t.Errorf("unexpected ANR: %+v", anr)
+	}
+}
+
+func TestParseProcessFailures(t *testing.T) {
+	output := `ACTIVITY MANAGER RUNNING PROCESSES (dumpsys activity processes)
+  All known processes:
+  *APP* UID 10085 ProcessRecord{a1b2c3 4500:com.example.app/u0a85}
+    user #0 uid=10085 gids={50085, 20085, 9997}
+    notResponding=true crashing=false
+  *APP* UID 10086 ProcessRecord{d4e5f6 4600:com.other.app/u0a86}
+    notResponding=true crashing=false
+`
+	events := parseProcessFailures(output, "com.example.app")
+	if len(events) != 1 || events[0].Kind != ANR || events[0].PID != 4500 {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestCrashWatcherSecondaryProcess(t *testing.T) {
+	watcher := &CrashWatcher{pkg: "com.example.app", events: make(chan CrashEvent, 16), crashed: make(chan struct{})}
+	watcher.report(&CrashEvent{Kind: FatalException, Package: "com.example.apps", PID: 4300})
+	if err := watcher.Err(); err != nil {
+		t.Fatalf("a crash of another package was reported: %v", err)
+	}
+	watcher.report(&CrashEvent{Kind: FatalException, Package: "com.example.app:remote", PID: 4321})
+	err, ok := watcher.Err().(*CrashError)
+	if !ok || err.Event.PID != 4321 {
+		t.Errorf("Err = %v; want the com.example.app:remote crash", watcher.Err())
+	}
+	if event := <-watcher.events; event.Package != "com.example.app:remote" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+}
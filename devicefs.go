@@ -0,0 +1,228 @@
+package adbtools
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+)
+
+// deviceFS is a device directory served over the sync protocol
+type deviceFS struct {
+	device *Device
+	root   string
+}
+
+// fsFile streams a device file while it is read; the transfer starts
+// on the first read and starts over when seeking away from it
+type fsFile struct {
+	fsys   *deviceFS
+	name   string
+	info   fs.FileInfo
+	reader *io.PipeReader
+	done   chan error
+	// position is where the transfer is, offset where the next read starts
+	position int64
+	offset   int64
+}
+
+// fsDir is an open device directory
+type fsDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+}
+
+// FS returns the device directory tree rooted at root as a file system,
+// implementing fs.FS, fs.ReadDirFS and fs.StatFS, such as to walk
+// /sdcard/DCIM with fs.WalkDir or serve it with http.FS.
+//
+// Files implement io.Seeker; the sync protocol has no offsets,
+// so seeking backwards transfers the file again from the start
+func (device *Device) FS(root string) fs.FS {
+	return &deviceFS{device: device, root: root}
+}
+
+// Open opens the named file or directory
+func (fsys *deviceFS) Open(name string) (fs.File, error) {
+	info, err := fsys.Stat(name)
+	if err != nil {
+		return nil, fsError("open", name, err)
+	}
+	if info.IsDir() {
+		entries, err := fsys.ReadDir(name)
+		if err != nil {
+			return nil, fsError("open", name, err)
+		}
+		return &fsDir{info: info, entries: entries}, nil
+	}
+	return &fsFile{fsys: fsys, name: name, info: info}, nil
+}
+
+// Stat returns the file info of the named file, following links to directories
+func (fsys *deviceFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	session, err := fsys.device.openSync()
+	if err != nil {
+		return nil, fsError("stat", name, err)
+	}
+	defer session.Close()
+	info, err := session.stat(fsys.remote(name))
+	if err != nil {
+		return nil, fsError("stat", name, err)
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if linked, err := session.stat(fsys.remote(name) + "/"); err == nil {
+			info = linked
+		}
+	}
+	file := info.(remoteFile)
+	file.name = path.Base(name)
+	return file, nil
+}
+
+// ReadDir lists the named directory, sorted by name
+func (fsys *deviceFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	files, err := fsys.device.ReadDir(fsys.remote(name))
+	if err != nil {
+		return nil, fsError("readdir", name, err)
+	}
+	entries := make([]fs.DirEntry, len(files))
+	for i, file := range files {
+		entries[i] = fs.FileInfoToDirEntry(file)
+	}
+	return entries, nil
+}
+
+func (fsys *deviceFS) remote(name string) string {
+	return path.Join(fsys.root, name)
+}
+
+func (file *fsFile) Stat() (fs.FileInfo, error) {
+	return file.info, nil
+}
+
+func (file *fsFile) Read(buffer []byte) (int, error) {
+	if file.reader != nil && file.position != file.offset {
+		if err := file.stop(); err != nil {
+			return 0, err
+		}
+	}
+	if file.reader == nil {
+		if file.offset >= file.info.Size() {
+			return 0, io.EOF
+		}
+		if err := file.start(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := file.reader.Read(buffer)
+	file.position += int64(n)
+	file.offset = file.position
+	return n, err
+}
+
+// Seek sets the offset of the next read
+func (file *fsFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += file.offset
+	case io.SeekEnd:
+		offset += file.info.Size()
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: file.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: file.name, Err: fs.ErrInvalid}
+	}
+	file.offset = offset
+	return offset, nil
+}
+
+// Close stops the transfer, if still running
+func (file *fsFile) Close() error {
+	return file.stop()
+}
+
+// start transfers the file and skips it up to the offset
+func (file *fsFile) start() error {
+	session, err := file.fsys.device.openSync()
+	if err != nil {
+		return fsError("read", file.name, err)
+	}
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := session.recv(file.fsys.remote(file.name), writer)
+		writer.CloseWithError(err)
+		session.Close()
+		done <- err
+	}()
+	file.reader, file.done, file.position = reader, done, 0
+	if file.offset > 0 {
+		skipped, err := io.CopyN(io.Discard, reader, file.offset)
+		file.position = skipped
+		if err != nil {
+			return fsError("seek", file.name, err)
+		}
+	}
+	return nil
+}
+
+// stop closes the running transfer, reporting its failure
+func (file *fsFile) stop() error {
+	if file.reader == nil {
+		return nil
+	}
+	file.reader.Close()
+	err := <-file.done
+	file.reader, file.done = nil, nil
+	if err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return err
+	}
+	return nil
+}
+
+func (dir *fsDir) Stat() (fs.FileInfo, error) {
+	return dir.info, nil
+}
+
+func (dir *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: dir.info.Name(), Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the next n entries, or all the remaining ones when n <= 0
+func (dir *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := dir.entries
+		dir.entries = nil
+		return entries, nil
+	}
+	if len(dir.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(dir.entries) {
+		n = len(dir.entries)
+	}
+	entries := dir.entries[:n]
+	dir.entries = dir.entries[n:]
+	return entries, nil
+}
+
+func (dir *fsDir) Close() error {
+	return nil
+}
+
+// fsError reports err with the file system relative name
+func fsError(op, name string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return &fs.PathError{Op: op, Path: name, Err: pathErr.Err}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
@@ -0,0 +1,164 @@
+package adbtools
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// startADBServer serves the sync sessions of the device transport
+// with the given files, as the adb server would. It returns the files
+// received by the sessions served so far, by "path,mode"
+func startADBServer(t *testing.T, files map[string][]byte) func() map[string]string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	t.Setenv("ADB_SERVER_SOCKET", "tcp:"+listener.Addr().String())
+	mu := sync.Mutex{}
+	daemons := []*syncDaemon{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			daemon := &syncDaemon{conn: conn, files: files, sent: map[string]string{}}
+			mu.Lock()
+			daemons = append(daemons, daemon)
+			mu.Unlock()
+			go func() {
+				// host:transport:<serial> and sync:
+				for i := 0; i < 2; i++ {
+					if _, err := readMessage(conn); err != nil {
+						conn.Close()
+						return
+					}
+					conn.Write([]byte("OKAY"))
+				}
+				daemon.serve()
+			}()
+		}
+	}()
+	return func() map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		sent := map[string]string{}
+		for _, daemon := range daemons {
+			for key, content := range daemon.sentFiles() {
+				sent[key] = content
+			}
+		}
+		return sent
+	}
+}
+
+func TestDeviceFS(t *testing.T) {
+	files := map[string][]byte{
+		"/sdcard/notes.txt":          []byte("hello"),
+		"/sdcard/DCIM/Camera/1.jpg":  []byte("jpeg"),
+		"/sdcard/DCIM/Camera/2.jpg":  []byte("jpeg2"),
+		"/sdcard/Download/empty.bin": {},
+	}
+	for i := 0; i < 20; i++ {
+		files["/sdcard/Download/file"+strconv.Itoa(i)] = []byte(strconv.Itoa(i))
+	}
+	startADBServer(t, files)
+
+	device := &Device{ID: "emulator-5554"}
+	fsys := device.FS("/sdcard")
+	if err := fstest.TestFS(fsys, "notes.txt", "DCIM/Camera/1.jpg", "DCIM/Camera/2.jpg", "Download/empty.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := fs.Glob(fsys, "DCIM/*/*.jpg")
+	if err != nil || len(matches) != 2 {
+		t.Errorf("fs.Glob = %v, %v", matches, err)
+	}
+	if _, err := fs.Stat(fsys, "missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("fs.Stat(missing) err = %v; want fs.ErrNotExist", err)
+	}
+
+	// closing before reading everything stops the transfer
+	file, err := fsys.Open("DCIM/Camera/2.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(file, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+}
+
+func TestDeviceFSHTTP(t *testing.T) {
+	startADBServer(t, map[string][]byte{
+		"/sdcard/notes.txt":   []byte("hello world"),
+		"/sdcard/Download/db": []byte("SQLite format 3"),
+	})
+	device := &Device{ID: "emulator-5554"}
+	server := httptest.NewServer(http.FileServer(http.FS(device.FS("/sdcard"))))
+	defer server.Close()
+
+	tests := []struct {
+		path, ranges string
+		status       int
+		body         string
+	}{
+		// without a known extension the content type is sniffed, seeking back after it
+		{"/Download/db", "", http.StatusOK, "SQLite format 3"},
+		{"/notes.txt", "bytes=6-10", http.StatusPartialContent, "world"},
+		{"/notes.txt", "bytes=0-4", http.StatusPartialContent, "hello"},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest("GET", server.URL+test.path, nil)
+		if len(test.ranges) > 0 {
+			request.Header.Set("Range", test.ranges)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != test.status || string(body) != test.body {
+			t.Errorf("GET %s %s = %d %q; want %d %q", test.path, test.ranges, response.StatusCode, body, test.status, test.body)
+		}
+	}
+
+	file, err := device.FS("/sdcard").Open("notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	seeker := file.(io.ReadSeeker)
+	buffer := make([]byte, 5)
+	for _, offset := range []int64{6, 0, -5} {
+		whence := io.SeekStart
+		if offset < 0 {
+			whence = io.SeekEnd
+		}
+		if _, err := seeker.Seek(offset, whence); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(seeker, buffer); err != nil {
+			t.Fatal(err)
+		}
+		want := map[int64]string{6: "world", 0: "hello", -5: "world"}[offset]
+		if string(buffer) != want {
+			t.Errorf("read at %d = %q; want %q", offset, buffer, want)
+		}
+	}
+	if _, err := seeker.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek accepted a negative offset")
+	}
+}
@@ -0,0 +1,228 @@
+package adbtools
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Size is a width and height in pixels
+type Size struct {
+	Width  int
+	Height int
+}
+
+// Insets are the pixels taken from each border, such as by a display cutout
+type Insets struct {
+	Left   int
+	Top    int
+	Right  int
+	Bottom int
+}
+
+// DisplayInfo holds the display configuration
+type DisplayInfo struct {
+	ID       int
+	Physical Size
+	// Override is the size set with SetDisplaySize; zero when not overridden
+	Override        Size
+	Density         int
+	OverrideDensity int
+	RefreshRate     float64
+	Cutout          Insets
+}
+
+var (
+	physicalSize     = regexp.MustCompile(`Physical size: (\d+)x(\d+)`)
+	overrideSize     = regexp.MustCompile(`Override size: (\d+)x(\d+)`)
+	physicalDensity  = regexp.MustCompile(`Physical density: (\d+)`)
+	overrideDensity  = regexp.MustCompile(`Override density: (\d+)`)
+	displayIDs       = regexp.MustCompile(`mDisplayId=(\d+)`)
+	displayFrameRate = []*regexp.Regexp{
+		regexp.MustCompile(`renderFrameRate ([\d.]+)`),
+		regexp.MustCompile(`, ([\d.]+) fps`),
+		regexp.MustCompile(`fps=([\d.]+)`),
+	}
+	displayUniqueID = regexp.MustCompile(`uniqueId "(\w+):([^"]*)"`)
+	displayCutout   = regexp.MustCompile(`cutout DisplayCutout\{insets=Rect\((\d+), (\d+) - (\d+), (\d+)\)`)
+)
+
+// Size returns the size in use: the override size, if any, or the physical one
+func (info DisplayInfo) Size() Size {
+	if info.Override.Width > 0 && info.Override.Height > 0 {
+		return info.Override
+	}
+	return info.Physical
+}
+
+// Display returns the configuration of the device display, as selected by device.DisplayID
+func (device *Device) Display() (DisplayInfo, error) {
+	info := DisplayInfo{ID: device.DisplayID}
+	output := device.Shell("adb shell wm size" + device.displayFlag())
+	matches := physicalSize.FindStringSubmatch(output)
+	if len(matches) == 0 {
+		return info, fmt.Errorf("Failed to fetch physical screen size; output: %s", output)
+	}
+	info.Physical.Width, _ = strconv.Atoi(matches[1])
+	info.Physical.Height, _ = strconv.Atoi(matches[2])
+	if matches := overrideSize.FindStringSubmatch(output); len(matches) > 0 {
+		info.Override.Width, _ = strconv.Atoi(matches[1])
+		info.Override.Height, _ = strconv.Atoi(matches[2])
+	}
+
+	output = device.Shell("adb shell wm density" + device.displayFlag())
+	matches = physicalDensity.FindStringSubmatch(output)
+	if len(matches) == 0 {
+		return info, fmt.Errorf("Failed to fetch screen density; output: %s", output)
+	}
+	info.Density, _ = strconv.Atoi(matches[1])
+	if matches := overrideDensity.FindStringSubmatch(output); len(matches) > 0 {
+		info.OverrideDensity, _ = strconv.Atoi(matches[1])
+	}
+
+	info.RefreshRate, info.Cutout = parseDisplayInfo(device.Shell("adb shell dumpsys display"), device.DisplayID)
+	return info, nil
+}
+
+// DisplayIDs lists the logical displays, such as secondary and virtual displays
+func (device *Device) DisplayIDs() ([]int, error) {
+	output := device.Shell("adb shell dumpsys display")
+	ids := []int{}
+	seen := map[int]bool{}
+	for _, matches := range displayIDs.FindAllStringSubmatch(output, -1) {
+		id, _ := strconv.Atoi(matches[1])
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("Failed to fetch the displays; output: %s", output)
+	}
+	return ids, nil
+}
+
+// SetDisplaySize overrides the display size, such as to test tablet layouts on a phone.
+//
+// Returns a function to be deferred setting the previous size back
+func (device *Device) SetDisplaySize(width, height int) (func(), error) {
+	info, err := device.Display()
+	if err != nil {
+		return func() {}, err
+	}
+	if device.Log {
+		log.Printf("setting display size to %dx%d", width, height)
+	}
+	output := strings.TrimSpace(device.Shell(fmt.Sprintf("adb shell wm size %dx%d%s", width, height, device.displayFlag())))
+	if len(output) > 0 {
+		return func() {}, fmt.Errorf("Failed to set display size; output: %s", output)
+	}
+	return func() {
+		previous := "reset"
+		if info.Override.Width > 0 {
+			previous = fmt.Sprintf("%dx%d", info.Override.Width, info.Override.Height)
+		}
+		if device.Log {
+			log.Printf("setting display size back to %s", previous)
+		}
+		device.Shell(fmt.Sprintf("adb shell wm size %s%s", previous, device.displayFlag()))
+	}, nil
+}
+
+// SetDensity overrides the display density in dpi.
+//
+// Returns a function to be deferred setting the previous density back
+func (device *Device) SetDensity(density int) (func(), error) {
+	info, err := device.Display()
+	if err != nil {
+		return func() {}, err
+	}
+	if device.Log {
+		log.Printf("setting display density to %d", density)
+	}
+	output := strings.TrimSpace(device.Shell(fmt.Sprintf("adb shell wm density %d%s", density, device.displayFlag())))
+	if len(output) > 0 {
+		return func() {}, fmt.Errorf("Failed to set display density; output: %s", output)
+	}
+	return func() {
+		previous := "reset"
+		if info.OverrideDensity > 0 {
+			previous = strconv.Itoa(info.OverrideDensity)
+		}
+		if device.Log {
+			log.Printf("setting display density back to %s", previous)
+		}
+		device.Shell(fmt.Sprintf("adb shell wm density %s%s", previous, device.displayFlag()))
+	}, nil
+}
+
+// screencapFlag returns the screencap display flag. Since Android 10 screencap
+// takes the SurfaceFlinger physical display ID, found in the local: unique ID
+// of the logical display; older releases take the logical ID
+func (device *Device) screencapFlag() (string, error) {
+	if device.DisplayID == 0 {
+		return "", nil
+	}
+	kind, id := parseDisplayUniqueID(device.Shell("adb shell dumpsys display"), device.DisplayID)
+	switch kind {
+	case "local":
+		return " -d " + id, nil
+	case "":
+		return device.displayFlag(), nil
+	}
+	return "", fmt.Errorf("display %d is a %s display; screencap only captures physical displays", device.DisplayID, kind)
+}
+
+func (device *Device) displayFlag() string {
+	if device.DisplayID != 0 {
+		return fmt.Sprintf(" -d %d", device.DisplayID)
+	}
+	return ""
+}
+
+// parseDisplayInfo reads the refresh rate and cutout insets of the display
+// from its DisplayInfo line of dumpsys display
+func parseDisplayInfo(output string, id int) (float64, Insets) {
+	line := ""
+	for _, item := range strings.Split(output, "\n") {
+		if !strings.Contains(item, "DisplayInfo{") || !strings.Contains(item, fmt.Sprintf("displayId %d,", id)) {
+			continue
+		}
+		line = item
+		// the override info holds the values in use
+		if strings.Contains(item, "mOverrideDisplayInfo") {
+			break
+		}
+	}
+	rate := 0.0
+	for _, pattern := range displayFrameRate {
+		if matches := pattern.FindStringSubmatch(line); len(matches) > 0 {
+			rate, _ = strconv.ParseFloat(matches[1], 64)
+			break
+		}
+	}
+	insets := Insets{}
+	if matches := displayCutout.FindStringSubmatch(line); len(matches) > 0 {
+		insets.Left, _ = strconv.Atoi(matches[1])
+		insets.Top, _ = strconv.Atoi(matches[2])
+		insets.Right, _ = strconv.Atoi(matches[3])
+		insets.Bottom, _ = strconv.Atoi(matches[4])
+	}
+	return rate, insets
+}
+
+// parseDisplayUniqueID returns the kind, such as local or virtual,
+// and the ID of the display device behind the logical display
+func parseDisplayUniqueID(output string, id int) (string, string) {
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.Contains(line, "DisplayInfo{") || !strings.Contains(line, fmt.Sprintf("displayId %d,", id)) {
+			continue
+		}
+		if matches := displayUniqueID.FindStringSubmatch(line); len(matches) > 0 {
+			return matches[1], matches[2]
+		}
+	}
+	return "", ""
+}
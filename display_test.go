@@ -0,0 +1,58 @@
+package adbtools
+
+import "testing"
+
+func TestParseDisplayInfo(t *testing.T) {
+	output := `Logical Displays: size=2
+  Display 0:
+    mDisplayId=0
+    mBaseDisplayInfo=DisplayInfo{"Built-in Screen", displayId 0, FLAG_SECURE, real 1080 x 2280, largest app 2280 x 2154, 60.0 fps, supportedModes [{id=1, width=1080, height=2280, fps=60.0}], cutout DisplayCutout{insets=Rect(0, 0 - 0, 0)}}
+    mOverrideDisplayInfo=DisplayInfo{"Built-in Screen", displayId 0, FLAG_SECURE, real 1080 x 2280, renderFrameRate 90.0, cutout DisplayCutout{insets=Rect(0, 136 - 0, 0) waterfall=Insets{left=0, top=0, right=0, bottom=0}}}
+  Display 2:
+    mDisplayId=2
+    mBaseDisplayInfo=DisplayInfo{"HDMI Screen", displayId 2, real 1920 x 1080, 30.0 fps}
+`
+	rate, insets := parseDisplayInfo(output, 0)
+	if rate != 90 || insets != (Insets{Top: 136}) {
+		t.Errorf("unexpected display 0: rate %v; insets %+v", rate, insets)
+	}
+	rate, insets = parseDisplayInfo(output, 2)
+	if rate != 30 || insets != (Insets{}) {
+		t.Errorf("unexpected display 2: rate %v; insets %+v", rate, insets)
+	}
+}
+
+func TestDisplayInfoSize(t *testing.T) {
+	info := DisplayInfo{Physical: Size{1080, 2280}}
+	if info.Size() != info.Physical {
+		t.Errorf("expected physical size: %+v", info.Size())
+	}
+	info.Override = Size{720, 1520}
+	if info.Size() != info.Override {
+		t.Errorf("expected override size: %+v", info.Size())
+	}
+}
+
+func TestParseDisplayUniqueID(t *testing.T) {
+	output := `    mBaseDisplayInfo=DisplayInfo{"Built-in Screen", displayId 0, FLAG_SECURE, uniqueId "local:4619827259835644672", app 1080 x 2280}
+    mBaseDisplayInfo=DisplayInfo{"HDMI Screen", displayId 2, uniqueId "local:4619827551948147201", app 1920 x 1080}
+    mBaseDisplayInfo=DisplayInfo{"Overlay #1", displayId 3, uniqueId "virtual:com.android.shell,10000,overlay,0", app 720 x 480}
+    mBaseDisplayInfo=DisplayInfo{"HDMI Screen", displayId 12, real 1920 x 1080}
+`
+	tests := []struct {
+		id   int
+		kind string
+		want string
+	}{
+		{2, "local", "4619827551948147201"},
+		{3, "virtual", "com.android.shell,10000,overlay,0"},
+		{1, "", ""},
+		{12, "", ""},
+	}
+	for _, test := range tests {
+		kind, id := parseDisplayUniqueID(output, test.id)
+		if kind != test.kind || id != test.want {
+			t.Errorf("parseDisplayUniqueID(%d) = %s, %s; want %s, %s", test.id, kind, id, test.kind, test.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package adbtools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Task is a unit of work run by a Fleet on a single device
+type Task struct {
+	Name string
+	// Run executes the task with the context given to Fleet.Run;
+	// the returned value is kept in TaskResult.Value
+	Run func(ctx context.Context, device *Device) (interface{}, error)
+}
+
+// TaskResult is the outcome of a Task
+type TaskResult struct {
+	Task     string
+	DeviceID string
+	Value    interface{}
+	Err      error
+	Attempts int
+	Duration time.Duration
+}
+
+// Fleet distributes tasks across many devices at once
+type Fleet struct {
+	Devices []*Device
+	// Concurrency is the max amount of simultaneous tasks per device; defaults to 1
+	Concurrency int
+	// Retries is how many times a task is retried on another device
+	// after its device is lost
+	Retries int
+	Log     bool
+}
+
+// NewFleet creates a fleet with all the connected and ready devices
+func NewFleet(Log bool) (*Fleet, error) {
+	devices, err := Devices(Log)
+	if err != nil {
+		return nil, err
+	}
+	fleet := &Fleet{Concurrency: 1, Retries: 1, Log: Log}
+	for i := range devices {
+		if !devices[i].DeviceReady() {
+			log.Printf("%s device is not ready; skipping", devices[i].ID)
+			continue
+		}
+		fleet.Devices = append(fleet.Devices, &devices[i])
+	}
+	if len(fleet.Devices) == 0 {
+		return nil, fmt.Errorf("no ready devices found")
+	}
+	return fleet, nil
+}
+
+// Online verifies if the device is still attached and in the device state
+func (device *Device) Online() bool {
+	return cleanString(device.Shell("adb get-state")) == "device"
+}
+
+// Run runs the tasks across the fleet devices and waits for all of them.
+//
+// A task that fails on a device that is no longer online is retried on
+// another device; the lost device receives no further tasks.
+//
+// Results are returned in the same order as the tasks
+func (fleet *Fleet) Run(ctx context.Context, tasks []Task) ([]TaskResult, error) {
+	if len(fleet.Devices) == 0 {
+		return nil, fmt.Errorf("invalid fleet; no devices")
+	}
+	concurrency := fleet.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	results := make([]TaskResult, len(tasks))
+	// the queue is never closed while a task is pending, and a pending
+	// task is queued at most once, so requeuing never blocks
+	queue := make(chan int, len(tasks))
+	pending := sync.WaitGroup{}
+	for i := range tasks {
+		results[i].Task = tasks[i].Name
+		pending.Add(1)
+		queue <- i
+	}
+	go func() {
+		pending.Wait()
+		close(queue)
+	}()
+
+	workers := sync.WaitGroup{}
+	for _, device := range fleet.Devices {
+		lost := new(int32)
+		for i := 0; i < concurrency; i++ {
+			workers.Add(1)
+			go func(device *Device) {
+				defer workers.Done()
+				for index := range queue {
+					if atomic.LoadInt32(lost) == 1 {
+						queue <- index
+						return
+					}
+					if err := ctx.Err(); err != nil {
+						results[index].Err = err
+						pending.Done()
+						continue
+					}
+					if !fleet.run(ctx, device, tasks[index], &results[index]) {
+						atomic.StoreInt32(lost, 1)
+						if results[index].Attempts <= fleet.Retries {
+							queue <- index
+							return
+						}
+						pending.Done()
+						return
+					}
+					pending.Done()
+				}
+			}(device)
+		}
+	}
+	workers.Wait()
+
+	// every worker is gone; whatever is left has no device to run on
+	for {
+		index, ok := <-queue
+		if !ok {
+			break
+		}
+		if results[index].Err == nil {
+			results[index].Err = fmt.Errorf("no healthy device left to run the task")
+		}
+		pending.Done()
+	}
+
+	failed := []string{}
+	for i := range results {
+		if results[i].Err == nil {
+			continue
+		}
+		name := results[i].Task
+		if len(name) == 0 {
+			name = "#" + strconv.Itoa(i)
+		}
+		failed = append(failed, name)
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("%d of %d tasks failed: %s", len(failed), len(tasks), strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+// run runs a single task and reports whether the device is still usable
+func (fleet *Fleet) run(ctx context.Context, device *Device, task Task, result *TaskResult) bool {
+	if fleet.Log {
+		log.Printf("running task '%s' on %s", task.Name, device.ID)
+	}
+	start := time.Now()
+	result.Attempts++
+	result.DeviceID = device.ID
+	result.Value, result.Err = task.Run(ctx, device)
+	result.Duration = time.Since(start)
+	if result.Err == nil || device.Online() {
+		return true
+	}
+	log.Printf("lost %s device while running task '%s': %v", device.ID, task.Name, result.Err)
+	return false
+}
+
+// InstrumentationShards splits an instrumentation run into tasks,
+// one per shard, to be run by a Fleet.
+//
+// Each TaskResult.Value holds an *InstrumentationResult
+func InstrumentationShards(testPkg, runner string, args InstrumentationArgs, shards int) []Task {
+	tasks := []Task{}
+	for i := 0; i < shards; i++ {
+		shardArgs := args
+		shardArgs.NumShards = shards
+		shardArgs.ShardIndex = i
+		tasks = append(tasks, Task{
+			Name: fmt.Sprintf("%s shard %d/%d", testPkg, i+1, shards),
+			Run: func(ctx context.Context, device *Device) (interface{}, error) {
+				result, err := device.RunInstrumentation(ctx, testPkg, runner, shardArgs)
+				if err != nil {
+					return result, err
+				}
+				if !result.Passed() {
+					return result, fmt.Errorf("%d of %d tests failed", result.Failed(), len(result.Tests))
+				}
+				return result, nil
+			},
+		})
+	}
+	return tasks
+}
@@ -0,0 +1,69 @@
+package adbtools
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestFleetRun(t *testing.T) {
+	fleet := &Fleet{
+		// the "lost-device" ID is never attached, so it is seen as offline after its first failure
+		Devices:     []*Device{{ID: "lost-device"}, {ID: "healthy-device"}},
+		Concurrency: 2,
+		Retries:     1,
+	}
+	tasks := []Task{}
+	for i := 0; i < 6; i++ {
+		i := i
+		tasks = append(tasks, Task{
+			Name: fmt.Sprintf("task %d", i),
+			Run: func(ctx context.Context, device *Device) (interface{}, error) {
+				if device.ID == "lost-device" {
+					return nil, fmt.Errorf("device disconnected")
+				}
+				return i, nil
+			},
+		})
+	}
+	results, err := fleet.Run(context.Background(), tasks)
+	if err != nil {
+		t.Fatalf("fleet.Run err: %v", err)
+	}
+	for i, result := range results {
+		if result.Value != i || result.DeviceID != "healthy-device" {
+			t.Errorf("unexpected result for task %d: %#v", i, result)
+		}
+	}
+}
+
+func TestFleetRunNoDeviceLeft(t *testing.T) {
+	fleet := &Fleet{Devices: []*Device{{ID: "lost-device"}}, Retries: 3}
+	results, err := fleet.Run(context.Background(), []Task{
+		{Name: "first", Run: func(context.Context, *Device) (interface{}, error) { return nil, fmt.Errorf("device disconnected") }},
+		{Name: "second", Run: func(context.Context, *Device) (interface{}, error) { return nil, nil }},
+	})
+	if err == nil {
+		t.Fatal("expected fleet.Run to fail without healthy devices")
+	}
+	for _, result := range results {
+		if result.Err == nil {
+			t.Errorf("expected %s task to fail", result.Task)
+		}
+	}
+}
+
+func TestFleetRunContext(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "fleet")
+	fleet := &Fleet{Devices: []*Device{{ID: "healthy-device"}}}
+	results, err := fleet.Run(ctx, []Task{
+		{Name: "context", Run: func(ctx context.Context, device *Device) (interface{}, error) { return ctx.Value(key{}), nil }},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Value != "fleet" {
+		t.Errorf("the task got another context; value %v", results[0].Value)
+	}
+}
@@ -0,0 +1,23 @@
+package adbtools
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeADB puts an adb script first in PATH; the script gets the adb arguments as $@
+func fakeADB(t *testing.T, script string) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "adb"), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// fakeDeviceShell fakes adb shell by running the command with the host sh,
+// which parses the joined arguments again just like the device shell does
+func fakeDeviceShell(t *testing.T) {
+	fakeADB(t, `[ "$1" = shell ] && shift
+exec sh -c "$*"`)
+}
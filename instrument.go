@@ -0,0 +1,343 @@
+package adbtools
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TestStatus is the status code reported by the instrumentation
+// for each test, as in INSTRUMENTATION_STATUS_CODE
+type TestStatus int
+
+// Status codes used by AndroidJUnitRunner and InstrumentationTestRunner
+const (
+	TestStarted           TestStatus = 1
+	TestPassed            TestStatus = 0
+	TestError             TestStatus = -1
+	TestFailed            TestStatus = -2
+	TestIgnored           TestStatus = -3
+	TestAssumptionFailure TestStatus = -4
+)
+
+func (status TestStatus) String() string {
+	switch status {
+	case TestStarted:
+		return "started"
+	case TestPassed:
+		return "passed"
+	case TestError:
+		return "error"
+	case TestFailed:
+		return "failed"
+	case TestIgnored:
+		return "ignored"
+	case TestAssumptionFailure:
+		return "assumption failure"
+	}
+	return fmt.Sprintf("status %d", int(status))
+}
+
+// InstrumentationArgs holds the optional arguments of RunInstrumentation
+type InstrumentationArgs struct {
+	// Class filters the tests by class or by method using
+	// the com.package.Class#method format
+	Class []string
+	// NotClass excludes classes or methods using the same format as Class
+	NotClass []string
+	// Package filters the tests by java package
+	Package string
+	// NumShards and ShardIndex split the suite; ignored when NumShards is 0
+	NumShards  int
+	ShardIndex int
+	// Extra holds any other -e key value argument
+	Extra map[string]string
+	// OnTest is called as soon as each test finishes
+	OnTest func(TestResult)
+}
+
+// TestResult is the outcome of a single instrumented test
+type TestResult struct {
+	Class    string
+	Name     string
+	Status   TestStatus
+	Stack    string
+	Duration time.Duration
+}
+
+// InstrumentationResult is the outcome of an instrumentation run
+type InstrumentationResult struct {
+	Package  string
+	Runner   string
+	Started  time.Time
+	Duration time.Duration
+	Tests    []TestResult
+	// Code is the INSTRUMENTATION_CODE; -1 means the run completed
+	Code int
+	// Result holds the INSTRUMENTATION_RESULT values
+	Result map[string]string
+	// Failure holds the INSTRUMENTATION_FAILED message, if any
+	Failure string
+}
+
+// Failed counts the failed and errored tests
+func (result *InstrumentationResult) Failed() int {
+	count := 0
+	for _, test := range result.Tests {
+		if test.Status == TestFailed || test.Status == TestError {
+			count++
+		}
+	}
+	return count
+}
+
+// Passed reports if the run completed without failures
+func (result *InstrumentationResult) Passed() bool {
+	return len(result.Failure) == 0 && result.Code == -1 && result.Failed() == 0
+}
+
+// RunInstrumentation runs the instrumented tests of testPkg with the given runner
+// such as androidx.test.runner.AndroidJUnitRunner
+//
+// The raw output is parsed while the tests run
+// and every finished test is reported to args.OnTest.
+// Cancelling the context stops the run
+func (device *Device) RunInstrumentation(ctx context.Context, testPkg, runner string, args InstrumentationArgs) (*InstrumentationResult, error) {
+	if len(testPkg) == 0 || len(runner) == 0 {
+		return nil, fmt.Errorf("invalid instrumentation; package and runner cannot be empty")
+	}
+	if args.NumShards > 0 && (args.ShardIndex < 0 || args.ShardIndex >= args.NumShards) {
+		return nil, fmt.Errorf("invalid shard index %d for %d shards", args.ShardIndex, args.NumShards)
+	}
+	if device.Log {
+		log.Printf("running %s/%s instrumentation", testPkg, runner)
+	}
+	cmd := device.command(ctx, append(append([]string{"shell", "am", "instrument", "-r", "-w"}, args.options()...), testPkg+"/"+runner)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("cmd.StdoutPipe err: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cmd.Start err: %v", err)
+	}
+	result := parseInstrumentation(stdout, args.OnTest)
+	result.Package = testPkg
+	result.Runner = runner
+	if err := cmd.Wait(); err != nil {
+		return result, fmt.Errorf("am instrument err: %v", err)
+	}
+	if len(result.Failure) > 0 {
+		return result, fmt.Errorf("instrumentation failed: %s", result.Failure)
+	}
+	return result, nil
+}
+
+// options returns the -e arguments, quoted for the device shell
+func (args InstrumentationArgs) options() []string {
+	options := []string{}
+	if len(args.Class) > 0 {
+		options = append(options, "-e", "class", shellQuote(strings.Join(args.Class, ",")))
+	}
+	if len(args.NotClass) > 0 {
+		options = append(options, "-e", "notClass", shellQuote(strings.Join(args.NotClass, ",")))
+	}
+	if len(args.Package) > 0 {
+		options = append(options, "-e", "package", shellQuote(args.Package))
+	}
+	if args.NumShards > 0 {
+		options = append(options,
+			"-e", "numShards", strconv.Itoa(args.NumShards),
+			"-e", "shardIndex", strconv.Itoa(args.ShardIndex),
+		)
+	}
+	keys := []string{}
+	for key := range args.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		options = append(options, "-e", key, shellQuote(args.Extra[key]))
+	}
+	return options
+}
+
+// parseInstrumentation parses the am instrument -r output
+//
+// Each status block is made of INSTRUMENTATION_STATUS key=value lines,
+// whose values may span many lines, closed by an INSTRUMENTATION_STATUS_CODE line
+func parseInstrumentation(r io.Reader, onTest func(TestResult)) *InstrumentationResult {
+	result := &InstrumentationResult{Started: time.Now(), Result: map[string]string{}}
+	status := map[string]string{}
+	started := map[string]time.Time{}
+	var values map[string]string
+	lastKey := ""
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, "INSTRUMENTATION_STATUS: "):
+			values, lastKey = status, setValue(status, strings.TrimPrefix(line, "INSTRUMENTATION_STATUS: "))
+		case strings.HasPrefix(line, "INSTRUMENTATION_RESULT: "):
+			values, lastKey = result.Result, setValue(result.Result, strings.TrimPrefix(line, "INSTRUMENTATION_RESULT: "))
+		case strings.HasPrefix(line, "INSTRUMENTATION_STATUS_CODE: "):
+			code, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "INSTRUMENTATION_STATUS_CODE: ")))
+			if err != nil {
+				log.Printf("invalid status code: %s", line)
+			}
+			key := status["class"] + "#" + status["test"]
+			if TestStatus(code) == TestStarted {
+				started[key] = time.Now()
+			} else if len(status["test"]) > 0 {
+				test := TestResult{
+					Class:  status["class"],
+					Name:   status["test"],
+					Status: TestStatus(code),
+					Stack:  strings.TrimSpace(status["stack"]),
+				}
+				if start, ok := started[key]; ok {
+					test.Duration = time.Since(start)
+				}
+				result.Tests = append(result.Tests, test)
+				if onTest != nil {
+					onTest(test)
+				}
+			}
+			status = map[string]string{}
+			values, lastKey = nil, ""
+		case strings.HasPrefix(line, "INSTRUMENTATION_CODE: "):
+			code, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "INSTRUMENTATION_CODE: ")))
+			if err != nil {
+				log.Printf("invalid instrumentation code: %s", line)
+			}
+			result.Code = code
+			values, lastKey = nil, ""
+		case strings.HasPrefix(line, "INSTRUMENTATION_FAILED: "):
+			result.Failure = strings.TrimPrefix(line, "INSTRUMENTATION_FAILED: ")
+			values, lastKey = nil, ""
+		case strings.HasPrefix(line, "INSTRUMENTATION_ABORTED: "):
+			result.Failure = strings.TrimPrefix(line, "INSTRUMENTATION_ABORTED: ")
+			values, lastKey = nil, ""
+		default:
+			// continuation of a multi-line value such as stack or stream
+			if values != nil && len(lastKey) > 0 {
+				values[lastKey] += "\n" + line
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil && len(result.Failure) == 0 {
+		result.Failure = fmt.Sprintf("failed to read instrumentation output: %v", err)
+	}
+	if len(started) > len(result.Tests) && len(result.Failure) == 0 {
+		result.Failure = "instrumentation ended with unfinished tests"
+	}
+	result.Duration = time.Since(result.Started)
+	return result
+}
+
+func setValue(values map[string]string, pair string) string {
+	kv := strings.SplitN(pair, "=", 2)
+	if len(kv) == 1 {
+		values[kv[0]] = ""
+		return kv[0]
+	}
+	values[kv[0]] = kv[1]
+	return kv[0]
+}
+
+type junitSuite struct {
+	XMLName   xml.Name    `xml:"testsuite"`
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Hostname  string      `xml:"hostname,attr,omitempty"`
+	Cases     []junitCase `xml:"testcase"`
+	SystemErr string      `xml:"system-err,omitempty"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the result as a JUnit XML test suite
+//
+// hostname is optional and is usually the device ID
+func (result *InstrumentationResult) WriteJUnit(w io.Writer, hostname string) error {
+	suite := junitSuite{
+		Name:      result.Package,
+		Tests:     len(result.Tests),
+		Time:      seconds(result.Duration),
+		Timestamp: result.Started.Format("2006-01-02T15:04:05"),
+		Hostname:  hostname,
+		SystemErr: result.Failure,
+	}
+	for _, test := range result.Tests {
+		testCase := junitCase{ClassName: test.Class, Name: test.Name, Time: seconds(test.Duration)}
+		failure := &junitFailure{Message: firstLine(test.Stack), Body: test.Stack}
+		switch test.Status {
+		case TestFailed:
+			suite.Failures++
+			testCase.Failure = failure
+		case TestError:
+			suite.Errors++
+			testCase.Error = failure
+		case TestIgnored, TestAssumptionFailure:
+			suite.Skipped++
+			testCase.Skipped = &struct{}{}
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("io.WriteString err: %v", err)
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suite); err != nil {
+		return fmt.Errorf("encoder.Encode err: %v", err)
+	}
+	return nil
+}
+
+// SaveJUnit writes the JUnit XML test suite to the given file path
+func (result *InstrumentationResult) SaveJUnit(path, hostname string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("os.Create err: %v", err)
+	}
+	if err := result.WriteJUnit(file, hostname); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func seconds(duration time.Duration) string {
+	return strconv.FormatFloat(duration.Seconds(), 'f', 3, 64)
+}
+
+func firstLine(text string) string {
+	return strings.SplitN(text, "\n", 2)[0]
+}
@@ -0,0 +1,145 @@
+package adbtools
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rawInstrumentation = `INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=1
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=2
+INSTRUMENTATION_STATUS: stream=
+com.example.LoginTest:
+INSTRUMENTATION_STATUS: test=validLogin
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=1
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=2
+INSTRUMENTATION_STATUS: stream=.
+INSTRUMENTATION_STATUS: test=validLogin
+INSTRUMENTATION_STATUS_CODE: 0
+INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=2
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=2
+INSTRUMENTATION_STATUS: stream=
+INSTRUMENTATION_STATUS: test=invalidLogin
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=2
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=2
+INSTRUMENTATION_STATUS: stack=java.lang.AssertionError: expected error
+	at com.example.LoginTest.invalidLogin(LoginTest.java:42)
+
+INSTRUMENTATION_STATUS: stream=
+Error in invalidLogin(com.example.LoginTest):
+java.lang.AssertionError: expected error
+INSTRUMENTATION_STATUS: test=invalidLogin
+INSTRUMENTATION_STATUS_CODE: -2
+INSTRUMENTATION_RESULT: stream=
+
+Time: 1.5
+
+FAILURES!!!
+Tests run: 2,  Failures: 1
+
+INSTRUMENTATION_CODE: -1
+`
+
+func TestParseInstrumentation(t *testing.T) {
+	finished := 0
+	result := parseInstrumentation(strings.NewReader(rawInstrumentation), func(TestResult) { finished++ })
+	if finished != 2 || len(result.Tests) != 2 {
+		t.Fatalf("expected 2 tests; reported: %d; parsed: %d", finished, len(result.Tests))
+	}
+	if result.Tests[0].Status != TestPassed || result.Tests[0].Name != "validLogin" {
+		t.Errorf("unexpected first test: %#v", result.Tests[0])
+	}
+	failed := result.Tests[1]
+	if failed.Status != TestFailed || failed.Class != "com.example.LoginTest" {
+		t.Errorf("unexpected second test: %#v", failed)
+	}
+	if !strings.HasPrefix(failed.Stack, "java.lang.AssertionError: expected error\n\tat com.example") {
+		t.Errorf("unexpected stack: %q", failed.Stack)
+	}
+	if result.Code != -1 || result.Failed() != 1 || result.Passed() {
+		t.Errorf("unexpected result: code %d; failed %d", result.Code, result.Failed())
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := result.WriteJUnit(buffer, "emulator-5554"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`tests="2"`, `failures="1"`, `<testcase classname="com.example.LoginTest" name="invalidLogin"`, `<failure message="java.lang.AssertionError: expected error">`} {
+		if !strings.Contains(buffer.String(), want) {
+			t.Errorf("junit output missing %s:\n%s", want, buffer.String())
+		}
+	}
+}
+
+func TestParseInstrumentationFailed(t *testing.T) {
+	result := parseInstrumentation(strings.NewReader("INSTRUMENTATION_FAILED: com.example.test/androidx.test.runner.AndroidJUnitRunner\nINSTRUMENTATION_CODE: 0\n"), nil)
+	if len(result.Failure) == 0 || result.Passed() {
+		t.Errorf("expected failed instrumentation: %#v", result)
+	}
+}
+
+func TestRunInstrumentationCancel(t *testing.T) {
+	fakeADB(t, "exec sleep 10")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	device := &Device{}
+	if _, err := device.RunInstrumentation(ctx, "com.example.test", "androidx.test.runner.AndroidJUnitRunner", InstrumentationArgs{}); err == nil {
+		t.Error("RunInstrumentation ignored the cancelled context")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RunInstrumentation took %v after the context was cancelled", elapsed)
+	}
+}
+
+func TestInstrumentationOptions(t *testing.T) {
+	args := InstrumentationArgs{
+		Class: []string{"com.example.Outer$Inner#test"},
+		Extra: map[string]string{"user": "ana maria", "debug": "false", "api": "https://example.com/?a=1&b=2"},
+	}
+	want := []string{
+		"-e", "class", `'com.example.Outer$Inner#test'`,
+		"-e", "api", `'https://example.com/?a=1&b=2'`,
+		"-e", "debug", `'false'`,
+		"-e", "user", `'ana maria'`,
+	}
+	if got := args.options(); !reflect.DeepEqual(got, want) {
+		t.Errorf("options = %q; want %q", got, want)
+	}
+
+	// the device shell gets the values back as given
+	received := filepath.Join(t.TempDir(), "args")
+	fakeADB(t, `[ "$1" = shell ] && shift
+eval "set -- $*"
+printf '%s\n' "$@" > `+received)
+	device := &Device{}
+	device.RunInstrumentation(context.Background(), "com.example.test", "androidx.test.runner.AndroidJUnitRunner", args)
+	content, _ := os.ReadFile(received)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	want = []string{
+		"am", "instrument", "-r", "-w",
+		"-e", "class", "com.example.Outer$Inner#test",
+		"-e", "api", "https://example.com/?a=1&b=2",
+		"-e", "debug", "false",
+		"-e", "user", "ana maria",
+		"com.example.test/androidx.test.runner.AndroidJUnitRunner",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("the device got %q; want %q", lines, want)
+	}
+}
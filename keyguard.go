@@ -0,0 +1,233 @@
+package adbtools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CredentialType is the kind of lock screen credential
+type CredentialType int
+
+// Credential types
+const (
+	CredentialSwipe CredentialType = iota
+	CredentialPIN
+	CredentialPassword
+	CredentialPattern
+)
+
+// Credential is a lock screen credential.
+//
+// Patterns are the sequence of the touched cells from 1 to 9,
+// counted from the top left to the bottom right, as in "14789" for an L
+type Credential struct {
+	Type   CredentialType
+	Secret string
+}
+
+var (
+	keyguardShowing = []*regexp.Regexp{
+		regexp.MustCompile(`mShowingLockscreen=true`),
+		regexp.MustCompile(`mDreamingLockscreen=true`),
+		regexp.MustCompile(`isStatusBarKeyguard=true`),
+		regexp.MustCompile(`KeyguardServiceDelegate\s+showing=true`),
+	}
+	patternView = regexp.MustCompile(`resource-id="com\.android\.systemui:id/lockPatternView"[^>]*bounds="\[(\d+),(\d+)\]\[(\d+),(\d+)\]"`)
+	validSecret = regexp.MustCompile(`^[^'\s]+$`)
+)
+
+// IsLocked verifies if the keyguard is showing
+func (device *Device) IsLocked() bool {
+	if device.Log {
+		log.Println("is device locked?")
+	}
+	return isKeyguardShowing(device.Shell("adb shell dumpsys window policy"))
+}
+
+// DeviceLocked verifies if the device requires the credential to be unlocked,
+// as reported by the trust manager
+func (device *Device) DeviceLocked() bool {
+	return strings.Contains(device.Shell("adb shell dumpsys trust"), "deviceLocked=1")
+}
+
+// Unlock wakes the device up and unlocks it with the given credential
+func (device *Device) Unlock(credential Credential) error {
+	device.WakeUp()
+	if !device.IsLocked() {
+		return nil
+	}
+	if device.Log {
+		log.Println("unlocking the device")
+	}
+	if err := device.ScreenSize(); err != nil {
+		return err
+	}
+	// swiping up dismisses the swipe lock or shows the bouncer
+	width, height := device.screen()
+	device.Swipe([4]int{width / 2, height * 4 / 5, width / 2, height / 5})
+	device.sleep(5)
+
+	switch credential.Type {
+	case CredentialSwipe:
+	case CredentialPIN, CredentialPassword:
+		if err := device.InputText(credential.Secret, false); err != nil {
+			return err
+		}
+		// KEYCODE_ENTER
+		device.Shell(device.input("keyevent 66"))
+	case CredentialPattern:
+		if err := device.drawPattern(credential.Secret); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid credential type %d", credential.Type)
+	}
+
+	for attempts := 5; attempts > 0; attempts-- {
+		device.sleep(5)
+		if !device.IsLocked() {
+			return nil
+		}
+	}
+	return fmt.Errorf("Failed to unlock the device; is the credential right?")
+}
+
+// SetLockCredential sets the lock screen credential using locksettings.
+// old is the current credential, or the zero Credential when there is none
+func (device *Device) SetLockCredential(credential, old Credential) error {
+	if err := credential.validate(); err != nil {
+		return err
+	}
+	command := map[CredentialType]string{
+		CredentialPIN:      "set-pin",
+		CredentialPassword: "set-password",
+		CredentialPattern:  "set-pattern",
+	}[credential.Type]
+	if len(command) == 0 {
+		return device.ClearLockCredential(old)
+	}
+	if device.Log {
+		log.Printf("setting lock credential with %s", command)
+	}
+	args := append(append([]string{"shell", "locksettings", command}, old.oldArgs()...), shellQuote(credential.Secret))
+	output, _ := device.command(context.Background(), args...).CombinedOutput()
+	if !strings.Contains(strings.ToLower(string(output)), "set to") {
+		return fmt.Errorf("Failed to set lock credential; output: %s", output)
+	}
+	return nil
+}
+
+// ClearLockCredential removes the lock screen credential, leaving the swipe lock.
+// old is the current credential
+func (device *Device) ClearLockCredential(old Credential) error {
+	if device.Log {
+		log.Println("clearing lock credential")
+	}
+	args := append([]string{"shell", "locksettings", "clear"}, old.oldArgs()...)
+	output, _ := device.command(context.Background(), args...).CombinedOutput()
+	if !strings.Contains(strings.ToLower(string(output)), "cleared") {
+		return fmt.Errorf("Failed to clear lock credential; output: %s", output)
+	}
+	return nil
+}
+
+func (credential Credential) validate() error {
+	switch credential.Type {
+	case CredentialSwipe:
+		return nil
+	case CredentialPIN:
+		if _, err := strconv.Atoi(credential.Secret); err != nil || len(credential.Secret) < 4 {
+			return fmt.Errorf("invalid pin; must have at least 4 digits")
+		}
+	case CredentialPassword:
+		if len(credential.Secret) < 4 || !validSecret.MatchString(credential.Secret) {
+			return fmt.Errorf("invalid password; must have at least 4 characters, without spaces or quotes")
+		}
+	case CredentialPattern:
+		if _, err := patternCells(credential.Secret); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid credential type %d", credential.Type)
+	}
+	return nil
+}
+
+func (credential Credential) oldArgs() []string {
+	if credential.Type == CredentialSwipe || len(credential.Secret) == 0 {
+		return nil
+	}
+	return []string{"--old", shellQuote(credential.Secret)}
+}
+
+// drawPattern draws the pattern as a single gesture over the pattern view
+func (device *Device) drawPattern(pattern string) error {
+	cells, err := patternCells(pattern)
+	if err != nil {
+		return err
+	}
+	screen, err := device.dumpScreen(true)
+	if err != nil {
+		return err
+	}
+	matches := patternView.FindStringSubmatch(screen)
+	if len(matches) == 0 {
+		return fmt.Errorf("pattern view not found on screen")
+	}
+	bounds := [4]int{}
+	for i := range bounds {
+		bounds[i], _ = strconv.Atoi(matches[i+1])
+	}
+	points := patternPoints(bounds, cells)
+	device.Shell(device.input(fmt.Sprintf("motionevent DOWN %d %d", points[0][0], points[0][1])))
+	for _, point := range points[1:] {
+		device.Shell(device.input(fmt.Sprintf("motionevent MOVE %d %d", point[0], point[1])))
+	}
+	last := points[len(points)-1]
+	device.Shell(device.input(fmt.Sprintf("motionevent UP %d %d", last[0], last[1])))
+	return nil
+}
+
+// patternCells converts the pattern into cell indexes from 0 to 8
+func patternCells(pattern string) ([]int, error) {
+	if len(pattern) < 4 {
+		return nil, fmt.Errorf("invalid pattern '%s'; must connect at least 4 cells", pattern)
+	}
+	cells := []int{}
+	used := map[rune]bool{}
+	for _, char := range pattern {
+		if char < '1' || char > '9' || used[char] {
+			return nil, fmt.Errorf("invalid pattern '%s'; cells must be unique digits from 1 to 9", pattern)
+		}
+		used[char] = true
+		cells = append(cells, int(char-'1'))
+	}
+	return cells, nil
+}
+
+// patternPoints returns the center of each cell of a 3x3 grid inside the [x1,y1,x2,y2] bounds
+func patternPoints(bounds [4]int, cells []int) [][2]int {
+	cellWidth := (bounds[2] - bounds[0]) / 3
+	cellHeight := (bounds[3] - bounds[1]) / 3
+	points := [][2]int{}
+	for _, cell := range cells {
+		points = append(points, [2]int{
+			bounds[0] + cellWidth*(cell%3) + cellWidth/2,
+			bounds[1] + cellHeight*(cell/3) + cellHeight/2,
+		})
+	}
+	return points
+}
+
+func isKeyguardShowing(output string) bool {
+	for _, pattern := range keyguardShowing {
+		if pattern.MatchString(output) {
+			return true
+		}
+	}
+	return false
+}
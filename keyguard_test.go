@@ -0,0 +1,79 @@
+package adbtools
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsKeyguardShowing(t *testing.T) {
+	tests := []struct {
+		output string
+		want   bool
+	}{
+		{"    mShowingLockscreen=true mShowingDream=false", true},
+		{"  isStatusBarKeyguard=false\n  mDreamingLockscreen=false", false},
+		{"    KeyguardServiceDelegate\n      showing=true\n      showingAndNotOccluded=true", true},
+		{"    KeyguardServiceDelegate\n      showing=false\n      inputRestricted=false", false},
+	}
+	for _, test := range tests {
+		if got := isKeyguardShowing(test.output); got != test.want {
+			t.Errorf("isKeyguardShowing(%q) = %v; want %v", test.output, got, test.want)
+		}
+	}
+}
+
+func TestPatternPoints(t *testing.T) {
+	cells, err := patternCells("14789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := patternPoints([4]int{0, 300, 900, 1200}, cells)
+	want := [][2]int{{150, 450}, {150, 750}, {150, 1050}, {450, 1050}, {750, 1050}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("patternPoints = %v; want %v", got, want)
+	}
+}
+
+func TestCredentialValidate(t *testing.T) {
+	tests := []struct {
+		credential Credential
+		valid      bool
+	}{
+		{Credential{Type: CredentialSwipe}, true},
+		{Credential{Type: CredentialPIN, Secret: "1234"}, true},
+		{Credential{Type: CredentialPIN, Secret: "12a4"}, false},
+		{Credential{Type: CredentialPassword, Secret: "it's"}, false},
+		{Credential{Type: CredentialPassword, Secret: "secret"}, true},
+		{Credential{Type: CredentialPattern, Secret: "1235"}, true},
+		{Credential{Type: CredentialPattern, Secret: "1231"}, false},
+		{Credential{Type: CredentialPattern, Secret: "120"}, false},
+	}
+	for _, test := range tests {
+		if err := test.credential.validate(); (err == nil) != test.valid {
+			t.Errorf("validate(%+v) = %v; want valid %v", test.credential, err, test.valid)
+		}
+	}
+}
+
+func TestSetLockCredentialQuoting(t *testing.T) {
+	args := filepath.Join(t.TempDir(), "args")
+	// the device shell parses the joined adb shell arguments again
+	fakeADB(t, `[ "$1" = shell ] && shift
+eval "set -- $*"
+printf '%s\n' "$@" > `+args+`
+echo "Password set to 'secret'"`)
+	device := &Device{}
+	credential := Credential{Type: CredentialPassword, Secret: "p@$$;w|d"}
+	old := Credential{Type: CredentialPassword, Secret: "o$ld&1"}
+	if err := device.SetLockCredential(credential, old); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(args)
+	want := []string{"locksettings", "set-password", "--old", "o$ld&1", "p@$$;w|d"}
+	if lines := strings.Split(strings.TrimSpace(string(got)), "\n"); !reflect.DeepEqual(lines, want) {
+		t.Errorf("the device got %q; want %q", lines, want)
+	}
+}
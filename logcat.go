@@ -0,0 +1,194 @@
+package adbtools
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Logcat buffers
+const (
+	BufferMain   = "main"
+	BufferSystem = "system"
+	BufferCrash  = "crash"
+	BufferEvents = "events"
+	BufferRadio  = "radio"
+	BufferAll    = "all"
+)
+
+// LogcatOptions holds the optional arguments of Logcat
+type LogcatOptions struct {
+	// Filters holds filter specs in the tag:priority format, such as ActivityManager:I or *:S
+	Filters []string
+	// Buffers defaults to the logcat default buffers
+	Buffers []string
+	// PID filters the entries of a single process
+	PID int
+	// Package filters the entries of the running package process
+	Package string
+	// Since prints the entries from the given time onwards, as logcat -T.
+	// It is compared against the device clock; see DeviceTime
+	Since time.Time
+	// Dump prints the current entries and closes the channel, as logcat -d
+	Dump bool
+	// Clear clears the buffers before streaming
+	Clear bool
+}
+
+// LogEntry is a parsed logcat line
+type LogEntry struct {
+	Time    time.Time
+	PID     int
+	TID     int
+	Level   string
+	Tag     string
+	Message string
+}
+
+func (entry LogEntry) String() string {
+	return fmt.Sprintf("%s %5d %5d %s %s: %s", entry.Time.Format("01-02 15:04:05.000"), entry.PID, entry.TID, entry.Level, entry.Tag, entry.Message)
+}
+
+var threadtime = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}|\d{2}-\d{2})\s+(\d{2}:\d{2}:\d{2}\.\d+)\s+(\d+)\s+(\d+)\s+([VDIWEFSA])\s+(.*?)\s*:(?: (.*))?$`)
+
+// DeviceTime returns the device clock, which may drift from the host clock
+func (device *Device) DeviceTime() (time.Time, error) {
+	return parseDeviceTime(device.Shell("adb shell date +%s.%N"))
+}
+
+// parseDeviceTime parses the date +%s.%N output; old toolboxes print %N as is
+func parseDeviceTime(output string) (time.Time, error) {
+	parts := strings.SplitN(strings.TrimSpace(output), ".", 2)
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to read the device clock; output: %s", output)
+	}
+	nanos := int64(0)
+	if len(parts) == 2 {
+		nanos, _ = strconv.ParseInt(parts[1], 10, 64)
+	}
+	return time.Unix(seconds, nanos), nil
+}
+
+// Logcat streams the parsed logcat -v threadtime entries until the context is done.
+//
+// The channel is closed when logcat exits
+func (device *Device) Logcat(ctx context.Context, opts LogcatOptions) (<-chan LogEntry, error) {
+	if opts.Clear {
+		if err := device.ClearLogcat(opts.Buffers...); err != nil {
+			return nil, err
+		}
+	}
+	// UTC timestamps do not depend on the device or host time zones
+	args := []string{"logcat", "-v", "threadtime", "-v", "year", "-v", "UTC"}
+	for _, buffer := range opts.Buffers {
+		args = append(args, "-b", buffer)
+	}
+	pid := opts.PID
+	if len(opts.Package) > 0 {
+		var err error
+		pid, err = device.PID(opts.Package)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if pid > 0 {
+		args = append(args, fmt.Sprintf("--pid=%d", pid))
+	}
+	if !opts.Since.IsZero() {
+		// the epoch format does not depend on the device time zone
+		args = append(args, "-T", fmt.Sprintf("%d.%03d", opts.Since.Unix(), opts.Since.Nanosecond()/int(time.Millisecond)))
+	}
+	if opts.Dump {
+		args = append(args, "-d")
+	}
+	for _, filter := range opts.Filters {
+		args = append(args, fmt.Sprintf("'%s'", filter))
+	}
+
+	cmd := device.command(ctx, "shell", strings.Join(args, " "))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("cmd.StdoutPipe err: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cmd.Start err: %v", err)
+	}
+	entries := make(chan LogEntry)
+	go func() {
+		defer close(entries)
+		defer cmd.Wait()
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			entry, ok := parseLogLine(scanner.Text())
+			if !ok {
+				continue
+			}
+			select {
+			case entries <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			log.Printf("logcat stopped: %v", err)
+		}
+	}()
+	return entries, nil
+}
+
+// ClearLogcat clears the given buffers or the default ones
+func (device *Device) ClearLogcat(buffers ...string) error {
+	cmd := "adb logcat -c"
+	for _, buffer := range buffers {
+		cmd += " -b " + buffer
+	}
+	output := strings.TrimSpace(device.Shell(cmd))
+	if len(output) > 0 {
+		return fmt.Errorf("Failed to clear logcat; output: %s", output)
+	}
+	return nil
+}
+
+// PID returns the process ID of the running package
+func (device *Device) PID(pkg string) (int, error) {
+	output := cleanString(device.Shell("adb shell pidof -s " + pkg))
+	pid, err := strconv.Atoi(output)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not running; output: %s", pkg, output)
+	}
+	return pid, nil
+}
+
+// parseLogLine parses a logcat -v threadtime -v UTC line, with or without -v year;
+// the time is returned in the host time zone
+func parseLogLine(line string) (LogEntry, bool) {
+	matches := threadtime.FindStringSubmatch(strings.TrimRight(line, "\r"))
+	if len(matches) == 0 {
+		return LogEntry{}, false
+	}
+	date := matches[1]
+	if len(date) == 5 {
+		date = strconv.Itoa(time.Now().UTC().Year()) + "-" + date
+	}
+	timestamp, err := time.ParseInLocation("2006-01-02 15:04:05.000", date+" "+matches[2], time.UTC)
+	if err != nil {
+		return LogEntry{}, false
+	}
+	pid, _ := strconv.Atoi(matches[3])
+	tid, _ := strconv.Atoi(matches[4])
+	return LogEntry{
+		Time:    timestamp.Local(),
+		PID:     pid,
+		TID:     tid,
+		Level:   matches[5],
+		Tag:     matches[6],
+		Message: matches[7],
+	}, true
+}
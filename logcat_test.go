@@ -0,0 +1,100 @@
+package adbtools
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want LogEntry
+		ok   bool
+	}{
+		{
+			line: "2026-10-18 17:20:01.123  1234  1256 I ActivityManager: Start proc 4321:com.android.chrome/u0a85",
+			want: LogEntry{Time: time.Date(2026, 10, 18, 17, 20, 1, 123000000, time.UTC), PID: 1234, TID: 1256, Level: "I", Tag: "ActivityManager", Message: "Start proc 4321:com.android.chrome/u0a85"},
+			ok:   true,
+		},
+		{
+			line: "2026-10-18 17:20:02.000   987   987 E AndroidRuntime: FATAL EXCEPTION: main",
+			want: LogEntry{Time: time.Date(2026, 10, 18, 17, 20, 2, 0, time.UTC), PID: 987, TID: 987, Level: "E", Tag: "AndroidRuntime", Message: "FATAL EXCEPTION: main"},
+			ok:   true,
+		},
+		{
+			line: "2026-10-18 17:20:03.500   100   200 D chromium : ",
+			want: LogEntry{Time: time.Date(2026, 10, 18, 17, 20, 3, 500000000, time.UTC), PID: 100, TID: 200, Level: "D", Tag: "chromium"},
+			ok:   true,
+		},
+		{line: "--------- beginning of main"},
+	}
+	for _, test := range tests {
+		got, ok := parseLogLine(test.line)
+		if ok != test.ok || !got.Time.Equal(test.want.Time) || got.PID != test.want.PID || got.TID != test.want.TID ||
+			got.Level != test.want.Level || got.Tag != test.want.Tag || got.Message != test.want.Message {
+			t.Errorf("parseLogLine(%q) = %+v, %v; want %+v, %v", test.line, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestParseLogLineTimeZone(t *testing.T) {
+	// time.Local is loaded once, so the host time zone is set in a child test process
+	if zone := os.Getenv("ADBTOOLS_TEST_TZ"); len(zone) > 0 {
+		entry, ok := parseLogLine("2026-10-18 17:20:01.123  1234  1256 I ActivityManager: Start proc")
+		want := time.Date(2026, 10, 18, 17, 20, 1, 123000000, time.UTC)
+		if !ok || !entry.Time.Equal(want) {
+			t.Fatalf("with TZ=%s parseLogLine time = %v; want %v", zone, entry.Time, want)
+		}
+		if name, _ := entry.Time.Zone(); name == "UTC" {
+			t.Fatalf("with TZ=%s parseLogLine time is not in the host time zone: %v", zone, entry.Time)
+		}
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestParseLogLineTimeZone$")
+	cmd.Env = append(os.Environ(), "ADBTOOLS_TEST_TZ=Asia/Tokyo", "TZ=Asia/Tokyo")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("%v; output: %s", err, output)
+	}
+}
+
+func TestParseDeviceTime(t *testing.T) {
+	tests := []struct {
+		output string
+		want   time.Time
+		ok     bool
+	}{
+		{"1792343401.250000000\r\n", time.Unix(1792343401, 250000000), true},
+		{"1792343401.N\n", time.Unix(1792343401, 0), true},
+		{"date: unknown option\n", time.Time{}, false},
+	}
+	for _, test := range tests {
+		got, err := parseDeviceTime(test.output)
+		if !got.Equal(test.want) || (err == nil) != test.ok {
+			t.Errorf("parseDeviceTime(%q) = %v, %v; want %v", test.output, got, err, test.want)
+		}
+	}
+}
+
+func TestLogcatSince(t *testing.T) {
+	args := filepath.Join(t.TempDir(), "args")
+	fakeADB(t, `echo "$*" > `+args)
+	device := &Device{}
+	entries, err := device.Logcat(context.Background(), LogcatOptions{Since: time.Unix(1792343401, 250000000), Dump: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range entries {
+	}
+	command, _ := os.ReadFile(args)
+	if !strings.Contains(string(command), " -v UTC ") {
+		t.Errorf("logcat command = %q; want -v UTC", command)
+	}
+	if !strings.Contains(string(command), " -T 1792343401.250 ") {
+		t.Errorf("logcat command = %q; want -T 1792343401.250", command)
+	}
+}
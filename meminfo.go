@@ -0,0 +1,189 @@
+package adbtools
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MemInfo holds the /proc/meminfo values in KB
+type MemInfo struct {
+	Total     int64
+	Free      int64
+	Available int64
+	Buffers   int64
+	Cached    int64
+	SwapTotal int64
+	SwapFree  int64
+	// Fields holds every /proc/meminfo value by its name
+	Fields map[string]int64
+}
+
+// AppMemInfo holds the dumpsys meminfo summary of a package in KB
+type AppMemInfo struct {
+	Package    string
+	PID        int
+	Time       time.Time
+	TotalPSS   int64
+	TotalRSS   int64
+	JavaHeap   int64
+	NativeHeap int64
+	Graphics   int64
+	Views      int
+	Activities int
+}
+
+// StorageInfo holds the df values of a mount point in KB
+type StorageInfo struct {
+	Filesystem string
+	MountedOn  string
+	Total      int64
+	Used       int64
+	Available  int64
+}
+
+var (
+	meminfoPID        = regexp.MustCompile(`MEMINFO in pid (\d+) \[([^\]]+)\]`)
+	meminfoJavaHeap   = regexp.MustCompile(`Java Heap:\s+(\d+)`)
+	meminfoNativeHeap = regexp.MustCompile(`Native Heap:\s+(\d+)`)
+	meminfoGraphics   = regexp.MustCompile(`Graphics:\s+(\d+)`)
+	meminfoTotalPSS   = regexp.MustCompile(`TOTAL(?: PSS)?:\s+(\d+)`)
+	meminfoTotalRSS   = regexp.MustCompile(`TOTAL RSS:\s+(\d+)`)
+	meminfoViews      = regexp.MustCompile(`\sViews:\s+(\d+)`)
+	meminfoActivities = regexp.MustCompile(`Activities:\s+(\d+)`)
+)
+
+// MemInfo parses the device /proc/meminfo
+func (device *Device) MemInfo() (MemInfo, error) {
+	output := device.Shell("adb shell cat /proc/meminfo")
+	info := parseMemInfo(output)
+	if len(info.Fields) == 0 {
+		return info, fmt.Errorf("Failed to fetch meminfo; output: %s", output)
+	}
+	return info, nil
+}
+
+// AppMemInfo parses the dumpsys meminfo of a running package
+func (device *Device) AppMemInfo(pkg string) (AppMemInfo, error) {
+	output := device.Shell("adb shell dumpsys meminfo " + pkg)
+	info, err := parseAppMemInfo(output)
+	if err != nil {
+		return info, err
+	}
+	if len(info.Package) == 0 {
+		info.Package = pkg
+	}
+	return info, nil
+}
+
+// Storage parses the df values of /data and /sdcard
+func (device *Device) Storage() ([]StorageInfo, error) {
+	output := device.Shell("adb shell df -k /data /sdcard")
+	storage := parseDF(output)
+	if len(storage) == 0 {
+		return nil, fmt.Errorf("Failed to fetch storage; output: %s", output)
+	}
+	return storage, nil
+}
+
+// LeakSuspected verifies if the total PSS of the samples, taken one per iteration,
+// grows more than maxGrowth KB along the iterations.
+//
+// The growth is estimated with a linear regression, so a single spike is not enough
+func LeakSuspected(samples []AppMemInfo, maxGrowth int64) bool {
+	return MemoryGrowth(samples) > float64(maxGrowth)
+}
+
+// MemoryGrowth estimates how many KB the total PSS grew from the first to the last sample
+func MemoryGrowth(samples []AppMemInfo) float64 {
+	n := float64(len(samples))
+	if n < 2 {
+		return 0
+	}
+	sumX, sumY, sumXY, sumXX := 0.0, 0.0, 0.0, 0.0
+	for i, sample := range samples {
+		x, y := float64(i), float64(sample.TotalPSS)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	slope := (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	return slope * (n - 1)
+}
+
+func parseMemInfo(output string) MemInfo {
+	info := MemInfo{Fields: map[string]int64{}}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		info.Fields[strings.TrimSuffix(fields[0], ":")] = value
+	}
+	info.Total = info.Fields["MemTotal"]
+	info.Free = info.Fields["MemFree"]
+	info.Available = info.Fields["MemAvailable"]
+	info.Buffers = info.Fields["Buffers"]
+	info.Cached = info.Fields["Cached"]
+	info.SwapTotal = info.Fields["SwapTotal"]
+	info.SwapFree = info.Fields["SwapFree"]
+	return info
+}
+
+func parseAppMemInfo(output string) (AppMemInfo, error) {
+	info := AppMemInfo{Time: time.Now()}
+	matches := meminfoPID.FindStringSubmatch(output)
+	if len(matches) == 0 {
+		return info, fmt.Errorf("Failed to fetch app meminfo; output: %s", output)
+	}
+	info.PID, _ = strconv.Atoi(matches[1])
+	info.Package = matches[2]
+
+	value := func(exp *regexp.Regexp) int64 {
+		matches := exp.FindStringSubmatch(output)
+		if len(matches) < 2 {
+			return 0
+		}
+		value, _ := strconv.ParseInt(matches[1], 10, 64)
+		return value
+	}
+	info.TotalPSS = value(meminfoTotalPSS)
+	info.TotalRSS = value(meminfoTotalRSS)
+	info.JavaHeap = value(meminfoJavaHeap)
+	info.NativeHeap = value(meminfoNativeHeap)
+	info.Graphics = value(meminfoGraphics)
+	info.Views = int(value(meminfoViews))
+	info.Activities = int(value(meminfoActivities))
+	return info, nil
+}
+
+func parseDF(output string) []StorageInfo {
+	storage := []StorageInfo{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || fields[0] == "Filesystem" {
+			continue
+		}
+		total, err1 := strconv.ParseInt(fields[1], 10, 64)
+		used, err2 := strconv.ParseInt(fields[2], 10, 64)
+		available, err3 := strconv.ParseInt(fields[3], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		storage = append(storage, StorageInfo{
+			Filesystem: fields[0],
+			MountedOn:  fields[len(fields)-1],
+			Total:      total,
+			Used:       used,
+			Available:  available,
+		})
+	}
+	return storage
+}
@@ -0,0 +1,69 @@
+package adbtools
+
+import "testing"
+
+const appMemInfo = `Applications Memory Usage (in Kilobytes):
+Uptime: 123456 Realtime: 123456
+
+** MEMINFO in pid 4321 [com.example.app] **
+                   Pss  Private  Private  SwapPss      Rss     Heap     Heap     Heap
+                 Total    Dirty    Clean    Dirty    Total     Size    Alloc     Free
+                ------   ------   ------   ------   ------   ------   ------   ------
+  Native Heap     8020     7980        0       12     9500    12288     9876     2411
+  Dalvik Heap     3100     3052        0        8     6000     6554     3277     3277
+        TOTAL    41234    30000     5000       20    80000    18842    13153     5688
+
+ App Summary
+                       Pss(KB)                        Rss(KB)
+                        ------                         ------
+           Java Heap:     5120                          10240
+         Native Heap:     7980                           9500
+                Code:     4000                          20000
+            Graphics:     9000                           9000
+
+           TOTAL PSS:    41234            TOTAL RSS:    80000       TOTAL SWAP PSS:       20
+
+ Objects
+               Views:       212         ViewRootImpl:        1
+         AppContexts:         5           Activities:        2
+`
+
+func TestParseAppMemInfo(t *testing.T) {
+	info, err := parseAppMemInfo(appMemInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AppMemInfo{Package: "com.example.app", PID: 4321, TotalPSS: 41234, TotalRSS: 80000, JavaHeap: 5120, NativeHeap: 7980, Graphics: 9000, Views: 212, Activities: 2}
+	info.Time = want.Time
+	if info != want {
+		t.Errorf("unexpected meminfo:\n got: %+v\nwant: %+v", info, want)
+	}
+}
+
+func TestParseMemInfo(t *testing.T) {
+	info := parseMemInfo("MemTotal:        2037180 kB\nMemFree:          153412 kB\nMemAvailable:    1002000 kB\nSwapTotal:        524284 kB\n")
+	if info.Total != 2037180 || info.Free != 153412 || info.Available != 1002000 || info.SwapTotal != 524284 {
+		t.Errorf("unexpected meminfo: %+v", info)
+	}
+}
+
+func TestParseDF(t *testing.T) {
+	storage := parseDF(`Filesystem           1K-blocks    Used Available Use% Mounted on
+/dev/block/dm-5        5971884 2356132   3599368  40% /data
+/dev/fuse              5971884 2356132   3599368  40% /storage/emulated
+`)
+	if len(storage) != 2 || storage[0].MountedOn != "/data" || storage[0].Available != 3599368 || storage[1].Total != 5971884 {
+		t.Errorf("unexpected storage: %+v", storage)
+	}
+}
+
+func TestLeakSuspected(t *testing.T) {
+	growing := []AppMemInfo{{TotalPSS: 40000}, {TotalPSS: 41000}, {TotalPSS: 42100}, {TotalPSS: 42900}, {TotalPSS: 44000}}
+	if !LeakSuspected(growing, 2000) {
+		t.Errorf("expected leak; growth: %.0f", MemoryGrowth(growing))
+	}
+	spike := []AppMemInfo{{TotalPSS: 40000}, {TotalPSS: 40100}, {TotalPSS: 48000}, {TotalPSS: 40050}, {TotalPSS: 40000}}
+	if LeakSuspected(spike, 2000) {
+		t.Errorf("unexpected leak; growth: %.0f", MemoryGrowth(spike))
+	}
+}
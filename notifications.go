@@ -0,0 +1,235 @@
+package adbtools
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ozzono/normalize"
+)
+
+// Notification is a notification posted on the device
+type Notification struct {
+	Package string
+	ID      int
+	Tag     string
+	Key     string
+	Channel string
+	Title   string
+	Text    string
+	Posted  time.Time
+	Actions []string
+}
+
+var (
+	notificationRecord  = regexp.MustCompile(`NotificationRecord\(0x[0-9a-f]+: pkg=(\S+) user=\S+ id=(-?\d+) tag=(\S*) .*?key=(\S+): Notification\(channel=(\S*) `)
+	notificationChannel = regexp.MustCompile(`mChannel=NotificationChannel\{mId='([^']*)'`)
+	notificationExtra   = regexp.MustCompile(`^(android\.\w+)=\w+ \((.*)\)$`)
+	notificationAction  = regexp.MustCompile(`^\[\d+\] "(.*)" ->`)
+	notificationWhen    = regexp.MustCompile(`^when=(\d+)$`)
+	screenNode          = regexp.MustCompile(`<node [^>]*>`)
+	nodeText            = regexp.MustCompile(` text="([^"]*)"`)
+	nodeBounds          = regexp.MustCompile(` bounds="(\[\d+,\d+\]\[\d+,\d+\])"`)
+)
+
+// Notifications returns the notifications currently posted, as listed by dumpsys notification
+func (device *Device) Notifications() ([]Notification, error) {
+	if device.Log {
+		log.Println("fetching notifications")
+	}
+	output := device.Shell("adb shell dumpsys notification --noredact")
+	if !strings.Contains(output, "Notification List:") {
+		return nil, fmt.Errorf("Failed to fetch notifications; output: %s", output)
+	}
+	return parseNotifications(output), nil
+}
+
+// OpenNotifications expands the notification shade
+func (device *Device) OpenNotifications() error {
+	return device.statusbar("expand-notifications")
+}
+
+// OpenQuickSettings expands the quick settings panel
+func (device *Device) OpenQuickSettings() error {
+	return device.statusbar("expand-settings")
+}
+
+// CloseNotifications collapses the notification shade and the quick settings panel
+func (device *Device) CloseNotifications() error {
+	return device.statusbar("collapse")
+}
+
+// TapNotification opens the notification shade and taps the first
+// notification showing the wanted text, either in its title or content.
+// The text matches as in WaitInScreen, ignoring case and accents
+func (device *Device) TapNotification(match string) error {
+	if len(match) == 0 {
+		return fmt.Errorf("invalid match; cannot be empty")
+	}
+	if err := device.OpenNotifications(); err != nil {
+		return err
+	}
+	for attempts := 5; ; attempts-- {
+		screen, err := device.XMLScreen(true)
+		if _, ok := err.(*WatcherError); ok {
+			device.CloseNotifications()
+			return err
+		}
+		if err != nil {
+			log.Printf("XMLScreen err: %v", err)
+		}
+		if bounds, ok := textBounds(screen, match); ok {
+			coords, err := XMLtoCoords(bounds)
+			if err != nil {
+				return err
+			}
+			if device.Log {
+				log.Printf("tapping notification '%s'", match)
+			}
+			device.TapScreen(coords[0], coords[1], 10)
+			return nil
+		}
+		if attempts == 1 {
+			device.CloseNotifications()
+			return fmt.Errorf("notification '%s' not found", match)
+		}
+		if err := device.wait(10); err != nil {
+			device.CloseNotifications()
+			return err
+		}
+	}
+}
+
+// textBounds returns the bounds of the first screen node whose text contains
+// the wanted one, comparing the unescaped text without case and accents
+func textBounds(screen, want string) (string, bool) {
+	want = strings.ToLower(normalize.Norm(want))
+	for _, node := range screenNode.FindAllString(screen, -1) {
+		text := nodeText.FindStringSubmatch(node)
+		bounds := nodeBounds.FindStringSubmatch(node)
+		if len(text) == 0 || len(bounds) == 0 {
+			continue
+		}
+		if strings.Contains(strings.ToLower(normalize.Norm(html.UnescapeString(text[1]))), want) {
+			return bounds[1], true
+		}
+	}
+	return "", false
+}
+
+// ClearNotifications dismisses every clearable notification
+// by tapping the shade's clear all button
+func (device *Device) ClearNotifications() error {
+	notifications, err := device.Notifications()
+	if err != nil {
+		return err
+	}
+	if len(notifications) == 0 {
+		return nil
+	}
+	if err := device.OpenNotifications(); err != nil {
+		return err
+	}
+	defer device.CloseNotifications()
+	if device.Log {
+		log.Println("clearing notifications")
+	}
+	device.sleep(5)
+	// ongoing notifications leave no clear all button to tap
+	if !device.HasInScreen(true, "dismiss_text", "clear all") {
+		return nil
+	}
+	return device.Exp2Tap(`<node[^>]*(?:resource-id="com\.android\.systemui:id/dismiss_text"|(?i:text="clear all"))[^>]*bounds="(\[\d+,\d+\]\[\d+,\d+\])"`)
+}
+
+func (device *Device) statusbar(command string) error {
+	if device.Log {
+		log.Printf("statusbar %s", command)
+	}
+	output := strings.TrimSpace(device.Shell("adb shell cmd statusbar " + command))
+	if len(output) > 0 {
+		return fmt.Errorf("Failed to %s statusbar; output: %s", command, output)
+	}
+	return nil
+}
+
+// parseNotifications reads the records of the Notification List section of dumpsys notification
+func parseNotifications(output string) []Notification {
+	notifications := []Notification{}
+	lines := strings.Split(output, "\n")
+	start := -1
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "Notification List:" {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		return notifications
+	}
+	sectionIndent := indentation(lines[start-1])
+
+	var current *Notification
+	inActions := false
+	for _, line := range lines[start:] {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 {
+			continue
+		}
+		if indentation(line) <= sectionIndent {
+			break
+		}
+		if matches := notificationRecord.FindStringSubmatch(trimmed); len(matches) > 0 {
+			if current != nil {
+				notifications = append(notifications, *current)
+			}
+			current = &Notification{Package: matches[1], Key: matches[4], Channel: matches[5]}
+			current.ID, _ = strconv.Atoi(matches[2])
+			if matches[3] != "null" {
+				current.Tag = matches[3]
+			}
+			inActions = false
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(trimmed, "actions={"):
+			inActions = true
+		case inActions && trimmed == "}":
+			inActions = false
+		case inActions:
+			if matches := notificationAction.FindStringSubmatch(trimmed); len(matches) > 0 {
+				current.Actions = append(current.Actions, matches[1])
+			}
+		}
+		if matches := notificationWhen.FindStringSubmatch(trimmed); len(matches) > 0 {
+			millis, _ := strconv.ParseInt(matches[1], 10, 64)
+			current.Posted = time.Unix(0, millis*int64(time.Millisecond))
+		}
+		if matches := notificationChannel.FindStringSubmatch(trimmed); len(matches) > 0 {
+			current.Channel = matches[1]
+		}
+		if matches := notificationExtra.FindStringSubmatch(trimmed); len(matches) > 0 {
+			switch matches[1] {
+			case "android.title":
+				current.Title = matches[2]
+			case "android.text":
+				current.Text = matches[2]
+			}
+		}
+	}
+	if current != nil {
+		notifications = append(notifications, *current)
+	}
+	return notifications
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
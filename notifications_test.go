@@ -0,0 +1,108 @@
+package adbtools
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const notificationDump = `Current Notification Manager state:
+  Notification List:
+    NotificationRecord(0x0b2f5a3c: pkg=com.example.chat user=UserHandle{0} id=42 tag=null importance=4 key=0|com.example.chat|42|null|10153: Notification(channel=messages shortcut=null contentView=null vibrate=null sound=null defaults=0x0 flags=0x10 color=0x00000000 vis=PRIVATE))
+      uid=10153 userId=0
+      opPkg=com.example.chat
+      notification=
+        contentIntent=PendingIntent{3c1e2a: PendingIntentRecord{...}}
+        when=1792343401000
+        actions={
+          [0] "Reply" -> PendingIntent{a1b2c3: PendingIntentRecord{...}}
+          [1] "Mark as read" -> PendingIntent{d4e5f6: PendingIntentRecord{...}}
+        }
+        extras={
+          android.title=String (Ana (work))
+          android.text=SpannableString (Lunch at 12, ok?)
+          android.template=String (android.app.Notification$MessagingStyle)
+        }
+      mChannel=NotificationChannel{mId='messages', mName=Messages, mImportance=4}
+    NotificationRecord(0x1c3d5e7f: pkg=com.example.sync user=UserHandle{0} id=-1 tag=sync importance=2 key=0|com.example.sync|-1|sync|10160: Notification(channel=background shortcut=null contentView=null vibrate=null sound=null defaults=0x0 flags=0x62 color=0x00000000 vis=PRIVATE))
+      notification=
+        when=1792343402000
+        extras={
+          android.title=String (Syncing)
+        }
+  Snoozed notifications:
+    NotificationRecord(0x2d4e6f80: pkg=com.example.old user=UserHandle{0} id=1 tag=null importance=3 key=0|com.example.old|1|null|10170: Notification(channel=old contentView=null))
+`
+
+func TestParseNotifications(t *testing.T) {
+	want := []Notification{
+		{
+			Package: "com.example.chat",
+			ID:      42,
+			Key:     "0|com.example.chat|42|null|10153",
+			Channel: "messages",
+			Title:   "Ana (work)",
+			Text:    "Lunch at 12, ok?",
+			Posted:  time.Unix(1792343401, 0),
+			Actions: []string{"Reply", "Mark as read"},
+		},
+		{
+			Package: "com.example.sync",
+			ID:      -1,
+			Tag:     "sync",
+			Key:     "0|com.example.sync|-1|sync|10160",
+			Channel: "background",
+			Title:   "Syncing",
+			Posted:  time.Unix(1792343402, 0),
+		},
+	}
+	got := parseNotifications(notificationDump)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseNotifications =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestTextBounds(t *testing.T) {
+	screen := `<hierarchy rotation="0"><node index="0" text="" resource-id="android:id/title" bounds="[0,0][1080,120]" />` +
+		`<node index="1" text="Tom &amp; Jerry" resource-id="android:id/title" bounds="[48,210][1032,270]" />` +
+		`<node index="2" text="Episódio novo" resource-id="android:id/text" bounds="[48,280][1032,340]" /></hierarchy>`
+	tests := []struct {
+		want   string
+		bounds string
+		found  bool
+	}{
+		{"tom & jerry", "[48,210][1032,270]", true},
+		{"TOM &", "[48,210][1032,270]", true},
+		{"EPISÓDIO novo", "[48,280][1032,340]", true},
+		{"&amp;", "", false},
+		{"Spike", "", false},
+	}
+	for _, test := range tests {
+		bounds, found := textBounds(screen, test.want)
+		if bounds != test.bounds || found != test.found {
+			t.Errorf("textBounds(%q) = %s, %v; want %s, %v", test.want, bounds, found, test.bounds, test.found)
+		}
+	}
+}
+
+func TestTapNotification(t *testing.T) {
+	taps := filepath.Join(t.TempDir(), "taps")
+	fakeADB(t, `case "$*" in
+*"uiautomator dump"*) echo "UI hierchary dumped to: /sdcard/window_dump.xml";;
+*"cat /sdcard/window_dump.xml"*) echo '<node index="1" text="Tom &amp; Jerry" bounds="[48,210][1032,270]" />';;
+*"input tap"*) echo "$*" >> `+taps+`;;
+esac`)
+	device := &Device{DefaultSleep: 1}
+	if err := device.TapNotification("tom & jerry"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(taps); strings.TrimSpace(string(got)) != "shell input tap 540 240" {
+		t.Errorf("TapNotification tapped %q; want the notification center", got)
+	}
+	if err := device.TapNotification("spike"); err == nil {
+		t.Error("TapNotification found a missing notification")
+	}
+}
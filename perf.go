@@ -0,0 +1,253 @@
+package adbtools
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FrameStats holds the dumpsys gfxinfo summary of a package
+// since its last reset
+type FrameStats struct {
+	TotalFrames  int
+	JankyFrames  int
+	JankyPercent float64
+	P50          time.Duration
+	P90          time.Duration
+	P95          time.Duration
+	P99          time.Duration
+	MissedVsync  int
+}
+
+// PerfSample is a single measure of a PerfSampler
+type PerfSample struct {
+	Time time.Time
+	// CPU is the process usage since the previous sample,
+	// where 100 is a whole core as in top
+	CPU    float64
+	Frames FrameStats
+}
+
+// PerfSampler periodically collects CPU and frame statistics of a package
+type PerfSampler struct {
+	device   *Device
+	pkg      string
+	interval time.Duration
+	cancel   context.CancelFunc
+	done     chan struct{}
+	mu       sync.Mutex
+	samples  []PerfSample
+}
+
+var (
+	gfxTotalFrames = regexp.MustCompile(`Total frames rendered: (\d+)`)
+	gfxJankyFrames = regexp.MustCompile(`Janky frames: (\d+) \(([\d.]+)%\)`)
+	gfxPercentile  = regexp.MustCompile(`(\d+)th percentile: (\d+)ms`)
+	gfxMissedVsync = regexp.MustCompile(`Number Missed Vsync: (\d+)`)
+)
+
+// FrameStats parses the dumpsys gfxinfo framestats summary of the package
+func (device *Device) FrameStats(pkg string) (FrameStats, error) {
+	output := device.Shell(fmt.Sprintf("adb shell dumpsys gfxinfo %s framestats", pkg))
+	return parseFrameStats(output)
+}
+
+// ResetFrameStats resets the frame statistics of the package,
+// so the next ones only cover the following scenario
+func (device *Device) ResetFrameStats(pkg string) error {
+	output := device.Shell(fmt.Sprintf("adb shell dumpsys gfxinfo %s reset", pkg))
+	if strings.Contains(output, "No process found") {
+		return fmt.Errorf("Failed to reset %s frame stats; output: %s", pkg, output)
+	}
+	return nil
+}
+
+// StartPerfSampler samples the package CPU usage and frame statistics
+// on every interval until Stop is called or the context is done
+func (device *Device) StartPerfSampler(ctx context.Context, pkg string, interval time.Duration) (*PerfSampler, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid sampling interval %v; must be > 0", interval)
+	}
+	pid, err := device.PID(pkg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	sampler := &PerfSampler{
+		device:   device,
+		pkg:      pkg,
+		interval: interval,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go sampler.run(ctx, pid)
+	return sampler, nil
+}
+
+func (sampler *PerfSampler) run(ctx context.Context, pid int) {
+	defer close(sampler.done)
+	ticker := time.NewTicker(sampler.interval)
+	defer ticker.Stop()
+	lastProc, lastTotal, _, err := sampler.device.cpuTicks(pid)
+	if err != nil {
+		log.Printf("cpuTicks err: %v", err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		sample := PerfSample{Time: time.Now()}
+		proc, total, cpus, err := sampler.device.cpuTicks(pid)
+		if err != nil {
+			// the process may have restarted
+			if newPID, pidErr := sampler.device.PID(sampler.pkg); pidErr == nil && newPID != pid {
+				pid = newPID
+				proc, total, cpus, err = sampler.device.cpuTicks(pid)
+				lastProc, lastTotal = proc, total
+			}
+		}
+		if err != nil {
+			log.Printf("cpuTicks err: %v", err)
+		} else if total > lastTotal {
+			sample.CPU = float64(proc-lastProc) / float64(total-lastTotal) * float64(cpus) * 100
+			lastProc, lastTotal = proc, total
+		}
+		sample.Frames, err = sampler.device.FrameStats(sampler.pkg)
+		if err != nil {
+			log.Printf("FrameStats err: %v", err)
+		}
+		sampler.mu.Lock()
+		sampler.samples = append(sampler.samples, sample)
+		sampler.mu.Unlock()
+	}
+}
+
+// Samples returns the samples collected so far
+func (sampler *PerfSampler) Samples() []PerfSample {
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+	return append([]PerfSample{}, sampler.samples...)
+}
+
+// Reset drops the collected samples and resets the frame statistics,
+// starting a new scenario
+func (sampler *PerfSampler) Reset() error {
+	sampler.mu.Lock()
+	sampler.samples = nil
+	sampler.mu.Unlock()
+	return sampler.device.ResetFrameStats(sampler.pkg)
+}
+
+// Stop stops sampling and returns the collected samples
+func (sampler *PerfSampler) Stop() []PerfSample {
+	sampler.cancel()
+	<-sampler.done
+	return sampler.Samples()
+}
+
+// WritePerfCSV writes the samples as CSV, one row per sample
+func WritePerfCSV(w io.Writer, samples []PerfSample) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"time", "cpu", "total_frames", "janky_frames", "janky_percent", "p50_ms", "p90_ms", "p95_ms", "p99_ms", "missed_vsync"})
+	for _, sample := range samples {
+		frames := sample.Frames
+		writer.Write([]string{
+			sample.Time.Format(time.RFC3339Nano),
+			strconv.FormatFloat(sample.CPU, 'f', 2, 64),
+			strconv.Itoa(frames.TotalFrames),
+			strconv.Itoa(frames.JankyFrames),
+			strconv.FormatFloat(frames.JankyPercent, 'f', 2, 64),
+			strconv.FormatInt(frames.P50.Milliseconds(), 10),
+			strconv.FormatInt(frames.P90.Milliseconds(), 10),
+			strconv.FormatInt(frames.P95.Milliseconds(), 10),
+			strconv.FormatInt(frames.P99.Milliseconds(), 10),
+			strconv.Itoa(frames.MissedVsync),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("writer.Error: %v", err)
+	}
+	return nil
+}
+
+// WritePerfJSON writes the samples as a JSON array
+func WritePerfJSON(w io.Writer, samples []PerfSample) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(samples); err != nil {
+		return fmt.Errorf("encoder.Encode err: %v", err)
+	}
+	return nil
+}
+
+// cpuTicks reads the process ticks from /proc/<pid>/stat,
+// and the ticks of all cores and the core count from /proc/stat
+func (device *Device) cpuTicks(pid int) (int64, int64, int, error) {
+	output := device.Shell(fmt.Sprintf("adb shell cat /proc/%d/stat /proc/stat", pid))
+	return parseCPUTicks(output, pid)
+}
+
+func parseCPUTicks(output string, pid int) (int64, int64, int, error) {
+	proc, total, cpus := int64(-1), int64(-1), 0
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.HasPrefix(line, strconv.Itoa(pid)+" ("):
+			// the process name may hold spaces, so fields are counted after it
+			fields := strings.Fields(line[strings.LastIndex(line, ")")+1:])
+			if len(fields) < 13 {
+				return 0, 0, 0, fmt.Errorf("invalid process stat: %s", line)
+			}
+			utime, _ := strconv.ParseInt(fields[11], 10, 64)
+			stime, _ := strconv.ParseInt(fields[12], 10, 64)
+			proc = utime + stime
+		case strings.HasPrefix(line, "cpu "):
+			total = 0
+			for _, field := range strings.Fields(line)[1:] {
+				value, _ := strconv.ParseInt(field, 10, 64)
+				total += value
+			}
+		case strings.HasPrefix(line, "cpu"):
+			cpus++
+		}
+	}
+	if proc < 0 || total < 0 || cpus == 0 {
+		return 0, 0, 0, fmt.Errorf("Failed to fetch cpu ticks of pid %d; output: %s", pid, output)
+	}
+	return proc, total, cpus, nil
+}
+
+func parseFrameStats(output string) (FrameStats, error) {
+	stats := FrameStats{}
+	matches := gfxTotalFrames.FindStringSubmatch(output)
+	if len(matches) == 0 {
+		return stats, fmt.Errorf("Failed to fetch frame stats; output: %s", output)
+	}
+	stats.TotalFrames, _ = strconv.Atoi(matches[1])
+	if matches := gfxJankyFrames.FindStringSubmatch(output); len(matches) > 0 {
+		stats.JankyFrames, _ = strconv.Atoi(matches[1])
+		stats.JankyPercent, _ = strconv.ParseFloat(matches[2], 64)
+	}
+	percentiles := map[string]*time.Duration{"50": &stats.P50, "90": &stats.P90, "95": &stats.P95, "99": &stats.P99}
+	for _, matches := range gfxPercentile.FindAllStringSubmatch(output, -1) {
+		// only the first summary is kept; later ones belong to other windows
+		if percentile, ok := percentiles[matches[1]]; ok && *percentile == 0 {
+			value, _ := strconv.Atoi(matches[2])
+			*percentile = time.Duration(value) * time.Millisecond
+		}
+	}
+	if matches := gfxMissedVsync.FindStringSubmatch(output); len(matches) > 0 {
+		stats.MissedVsync, _ = strconv.Atoi(matches[1])
+	}
+	return stats, nil
+}
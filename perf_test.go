@@ -0,0 +1,69 @@
+package adbtools
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFrameStats(t *testing.T) {
+	stats, err := parseFrameStats(`Applications Graphics Acceleration Info:
+** Graphics info for pid 4321 [com.example.app] **
+
+Stats since: 123456789ns
+Total frames rendered: 1200
+Janky frames: 54 (4.50%)
+50th percentile: 7ms
+90th percentile: 13ms
+95th percentile: 19ms
+99th percentile: 42ms
+Number Missed Vsync: 6
+Number High input latency: 0
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FrameStats{TotalFrames: 1200, JankyFrames: 54, JankyPercent: 4.5, P50: 7 * time.Millisecond, P90: 13 * time.Millisecond, P95: 19 * time.Millisecond, P99: 42 * time.Millisecond, MissedVsync: 6}
+	if stats != want {
+		t.Errorf("unexpected frame stats:\n got: %+v\nwant: %+v", stats, want)
+	}
+}
+
+func TestParseCPUTicks(t *testing.T) {
+	output := `4321 (com.example.app) S 300 300 0 0 -1 1077952832 40000 0 100 0 250 120 0 0 10 -10 30 0 5000 1500000000 30000
+cpu  10000 200 3000 50000 100 0 50 0 0 0
+cpu0 5000 100 1500 25000 50 0 25 0 0 0
+cpu1 5000 100 1500 25000 50 0 25 0 0 0
+intr 123456
+`
+	proc, total, cpus, err := parseCPUTicks(output, 4321)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proc != 370 || total != 63350 || cpus != 2 {
+		t.Errorf("unexpected ticks: proc %d; total %d; cpus %d", proc, total, cpus)
+	}
+}
+
+func TestWritePerfCSV(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	samples := []PerfSample{{Time: time.Date(2026, 10, 18, 17, 20, 0, 0, time.UTC), CPU: 12.5, Frames: FrameStats{TotalFrames: 10, P50: 7 * time.Millisecond}}}
+	if err := WritePerfCSV(buffer, samples); err != nil {
+		t.Fatal(err)
+	}
+	rows := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(rows) != 2 || rows[1] != "2026-10-18T17:20:00Z,12.50,10,0,0.00,7,0,0,0,0" {
+		t.Errorf("unexpected csv:\n%s", buffer.String())
+	}
+}
+
+func TestStartPerfSamplerInterval(t *testing.T) {
+	device := &Device{}
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if sampler, err := device.StartPerfSampler(context.Background(), "com.example.app", interval); err == nil || sampler != nil {
+			t.Errorf("StartPerfSampler(%v) = %v, %v; want an error", interval, sampler, err)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package adbtools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DeviceFilter selects which devices a Pool may lease; zero values match any device
+type DeviceFilter struct {
+	MinSDK    int
+	MaxSDK    int
+	Model     string
+	ABI       string
+	MinWidth  int
+	MinHeight int
+}
+
+// HealthCheck holds the requirements a device must meet before being leased;
+// zero values skip the check
+type HealthCheck struct {
+	ScreenOn   bool
+	MinBattery int
+	// MinFreeStorage is the minimum free space of /data in KB
+	MinFreeStorage int64
+}
+
+// Pool leases devices exclusively, both inside the process and
+// across processes through lock files
+type Pool struct {
+	Health HealthCheck
+	// LockDir holds the cross-process lock files; defaults to $TMPDIR/adbtools
+	LockDir string
+	// Quarantine is how long an unhealthy device is kept out of the pool; defaults to 5 minutes
+	Quarantine time.Duration
+	// PollInterval is the wait between attempts while no device is available; defaults to 1 second
+	PollInterval time.Duration
+	Log          bool
+
+	mu          sync.Mutex
+	leased      map[string]bool
+	quarantined map[string]quarantine
+}
+
+type quarantine struct {
+	reason string
+	until  time.Time
+}
+
+// Lease is an exclusive hold of a pool device
+type Lease struct {
+	Device  *Device
+	release func()
+	once    sync.Once
+}
+
+// Release returns the device to the pool
+func (lease *Lease) Release() {
+	lease.once.Do(lease.release)
+}
+
+// NewPool creates a device pool with the given health requirements
+func NewPool(health HealthCheck, Log bool) *Pool {
+	return &Pool{
+		Health:       health,
+		LockDir:      filepath.Join(os.TempDir(), "adbtools"),
+		Quarantine:   5 * time.Minute,
+		PollInterval: time.Second,
+		Log:          Log,
+		leased:       map[string]bool{},
+		quarantined:  map[string]quarantine{},
+	}
+}
+
+// Acquire leases the first healthy device matching the filter,
+// waiting until one is available or the context is done
+func (pool *Pool) Acquire(ctx context.Context, filter DeviceFilter) (*Lease, error) {
+	for {
+		lease, err := pool.TryAcquire(filter)
+		if err == nil {
+			return lease, nil
+		}
+		if pool.Log {
+			log.Printf("no device available: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to acquire device: %v; last err: %v", ctx.Err(), err)
+		case <-time.After(pool.pollInterval()):
+		}
+	}
+}
+
+// TryAcquire leases the first healthy device matching the filter without waiting
+func (pool *Pool) TryAcquire(filter DeviceFilter) (*Lease, error) {
+	devices, err := Devices(pool.Log)
+	if err != nil {
+		return nil, err
+	}
+	for i := range devices {
+		device := &devices[i]
+		if !pool.reserve(device.ID) {
+			continue
+		}
+		unlock, err := lockFile(pool.lockDir(), device.ID)
+		if err != nil {
+			if pool.Log {
+				log.Printf("%s is locked by another process: %v", device.ID, err)
+			}
+			pool.unreserve(device.ID)
+			continue
+		}
+		if !filter.matches(device) {
+			unlock()
+			pool.unreserve(device.ID)
+			continue
+		}
+		if err := pool.Health.check(device); err != nil {
+			log.Printf("quarantining %s: %v", device.ID, err)
+			unlock()
+			pool.mu.Lock()
+			pool.quarantined[device.ID] = quarantine{reason: err.Error(), until: time.Now().Add(pool.quarantine())}
+			delete(pool.leased, device.ID)
+			pool.mu.Unlock()
+			continue
+		}
+		if pool.Log {
+			log.Printf("leasing %s", device.ID)
+		}
+		return &Lease{Device: device, release: func() {
+			unlock()
+			pool.unreserve(device.ID)
+			if pool.Log {
+				log.Printf("released %s", device.ID)
+			}
+		}}, nil
+	}
+	return nil, fmt.Errorf("no free device matches the filter %+v", filter)
+}
+
+// Quarantined lists the quarantined devices and the reason of each quarantine
+func (pool *Pool) Quarantined() map[string]string {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	output := map[string]string{}
+	for id, item := range pool.quarantined {
+		if time.Now().Before(item.until) {
+			output[id] = item.reason
+		}
+	}
+	return output
+}
+
+// reserve marks the device as leased inside the process,
+// unless it is already leased or quarantined
+func (pool *Pool) reserve(id string) bool {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.leased == nil {
+		pool.leased = map[string]bool{}
+		pool.quarantined = map[string]quarantine{}
+	}
+	if pool.leased[id] {
+		return false
+	}
+	if item, ok := pool.quarantined[id]; ok {
+		if time.Now().Before(item.until) {
+			return false
+		}
+		delete(pool.quarantined, id)
+	}
+	pool.leased[id] = true
+	return true
+}
+
+func (pool *Pool) unreserve(id string) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	delete(pool.leased, id)
+}
+
+func (pool *Pool) lockDir() string {
+	if len(pool.LockDir) == 0 {
+		return filepath.Join(os.TempDir(), "adbtools")
+	}
+	return pool.LockDir
+}
+
+func (pool *Pool) quarantine() time.Duration {
+	if pool.Quarantine <= 0 {
+		return 5 * time.Minute
+	}
+	return pool.Quarantine
+}
+
+func (pool *Pool) pollInterval() time.Duration {
+	if pool.PollInterval <= 0 {
+		return time.Second
+	}
+	return pool.PollInterval
+}
+
+// lockFile takes an exclusive flock on the device lock file.
+// The file is never removed; the kernel releases the lock when the
+// holder exits, so locks of dead processes need no takeover
+func lockFile(dir, id string) (func(), error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll err: %v", err)
+	}
+	path := filepath.Join(dir, regexp.MustCompile(`[^\w.-]`).ReplaceAllString(id, "_")+".lock")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("os.OpenFile err: %v", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("%s is already locked", path)
+		}
+		return nil, fmt.Errorf("syscall.Flock err: %v", err)
+	}
+	// the pid only helps finding the holder
+	file.Truncate(0)
+	file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	return func() {
+		file.Truncate(0)
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+func (filter DeviceFilter) matches(device *Device) bool {
+	if filter.MinSDK > 0 || filter.MaxSDK > 0 {
+		sdk, err := device.SDK()
+		if err != nil || sdk < filter.MinSDK || (filter.MaxSDK > 0 && sdk > filter.MaxSDK) {
+			return false
+		}
+	}
+	if len(filter.Model) > 0 && !strings.EqualFold(device.Model(), filter.Model) {
+		return false
+	}
+	if len(filter.ABI) > 0 {
+		found := false
+		for _, abi := range device.ABIs() {
+			if abi == filter.ABI {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if filter.MinWidth > 0 || filter.MinHeight > 0 {
+		if err := device.ScreenSize(); err != nil {
+			return false
+		}
+		if width, height := device.screen(); width < filter.MinWidth || height < filter.MinHeight {
+			return false
+		}
+	}
+	return true
+}
+
+func (health HealthCheck) check(device *Device) error {
+	if !device.DeviceReady() {
+		return fmt.Errorf("boot not completed")
+	}
+	if health.ScreenOn && !device.IsScreenON() {
+		device.WakeUp()
+		if !device.IsScreenON() {
+			return fmt.Errorf("screen is off")
+		}
+	}
+	if health.MinBattery > 0 {
+		battery, err := device.Battery()
+		if err != nil {
+			return err
+		}
+		if battery.Level < health.MinBattery {
+			return fmt.Errorf("battery level %d%% is below %d%%", battery.Level, health.MinBattery)
+		}
+	}
+	if health.MinFreeStorage > 0 {
+		storage, err := device.Storage()
+		if err != nil {
+			return err
+		}
+		free := int64(-1)
+		for _, item := range storage {
+			if item.MountedOn == "/data" {
+				free = item.Available
+			}
+		}
+		if free < 0 {
+			return fmt.Errorf("/data partition not found")
+		}
+		if free < health.MinFreeStorage {
+			return fmt.Errorf("free storage %dKB is below %dKB", free, health.MinFreeStorage)
+		}
+	}
+	return nil
+}
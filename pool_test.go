@@ -0,0 +1,85 @@
+package adbtools
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockFile(t *testing.T) {
+	dir := t.TempDir()
+	unlock, err := lockFile(dir, "192.168.0.10:5555")
+	if err != nil {
+		t.Fatalf("lockFile err: %v", err)
+	}
+	if _, err := lockFile(dir, "192.168.0.10:5555"); err == nil {
+		t.Fatal("expected the second lock to fail")
+	}
+	unlock()
+	unlock, err = lockFile(dir, "192.168.0.10:5555")
+	if err != nil {
+		t.Fatalf("failed to lock after release: %v", err)
+	}
+	unlock()
+
+	// a lock file left behind by a dead process holds no flock
+	stale := filepath.Join(dir, "emulator-5554.lock")
+	if err := os.WriteFile(stale, []byte("999999999"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err = lockFile(dir, "emulator-5554")
+	if err != nil {
+		t.Fatalf("failed to take over stale lock: %v", err)
+	}
+	unlock()
+}
+
+func TestLockFileConcurrentTakeover(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "emulator-5554.lock"), []byte("999999999"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		start := make(chan struct{})
+		results := make(chan func(), 2)
+		for j := 0; j < 2; j++ {
+			go func() {
+				<-start
+				unlock, err := lockFile(dir, "emulator-5554")
+				if err != nil {
+					unlock = nil
+				}
+				results <- unlock
+			}()
+		}
+		close(start)
+		owners := 0
+		unlocks := []func(){}
+		for j := 0; j < 2; j++ {
+			if unlock := <-results; unlock != nil {
+				owners++
+				unlocks = append(unlocks, unlock)
+			}
+		}
+		for _, unlock := range unlocks {
+			unlock()
+		}
+		if owners != 1 {
+			t.Fatalf("attempt %d: %d callers own the stale lock; want 1", i, owners)
+		}
+	}
+}
+
+func TestPoolReserve(t *testing.T) {
+	pool := NewPool(HealthCheck{}, false)
+	if !pool.reserve("emulator-5554") {
+		t.Fatal("failed to reserve a free device")
+	}
+	if pool.reserve("emulator-5554") {
+		t.Error("reserved a leased device")
+	}
+	pool.unreserve("emulator-5554")
+	if !pool.reserve("emulator-5554") {
+		t.Error("failed to reserve a released device")
+	}
+}
@@ -0,0 +1,95 @@
+package adbtools
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// PrepareForTesting applies a profile that keeps UI tests stable:
+// no animations, screen always awake and unlocked, fixed portrait
+// orientation, no spellcheck or autofill, and no keyboard in the way.
+//
+// Returns a function to be deferred setting everything back
+func (device *Device) PrepareForTesting() (func(), error) {
+	if device.Log {
+		log.Println("preparing device for testing")
+	}
+	restores := []func(){}
+	restore := func() {
+		for i := len(restores) - 1; i >= 0; i-- {
+			restores[i]()
+		}
+	}
+	fail := func(err error) (func(), error) {
+		restore()
+		return func() {}, err
+	}
+
+	settings := []struct{ namespace, key, value string }{
+		{SettingsGlobal, "window_animation_scale", "0"},
+		{SettingsGlobal, "transition_animation_scale", "0"},
+		{SettingsGlobal, "animator_duration_scale", "0"},
+		// stays awake on AC, USB and wireless charging
+		{SettingsGlobal, "stay_on_while_plugged_in", "7"},
+		{SettingsSystem, "user_rotation", "0"},
+		{SettingsSecure, "spell_checker_enabled", "0"},
+		{SettingsSecure, "autofill_service", "null"},
+		{SettingsSecure, "show_ime_with_hard_keyboard", "0"},
+	}
+	for _, setting := range settings {
+		reset, err := device.PutSetting(setting.namespace, setting.key, setting.value)
+		if err != nil {
+			return fail(err)
+		}
+		restores = append(restores, reset)
+	}
+
+	reset, err := device.ScreenTimeout(30 * time.Minute)
+	if err != nil {
+		return fail(err)
+	}
+	restores = append(restores, reset)
+
+	reset, err = device.AutoRotate(false)
+	if err != nil {
+		return fail(err)
+	}
+	restores = append(restores, reset)
+
+	device.WakeUp()
+	if err := device.DismissKeyguard(); err != nil {
+		return fail(err)
+	}
+	device.HideKeyboard()
+	return restore, nil
+}
+
+// DismissKeyguard dismisses the lock screen when it has no credential
+func (device *Device) DismissKeyguard() error {
+	if device.Log {
+		log.Println("dismissing keyguard")
+	}
+	output := strings.TrimSpace(device.Shell("adb shell wm dismiss-keyguard"))
+	if len(output) > 0 {
+		return fmt.Errorf("Failed to dismiss keyguard; output: %s", output)
+	}
+	return nil
+}
+
+// IsKeyboardShown verifies if the soft keyboard is on screen
+func (device *Device) IsKeyboardShown() bool {
+	return strings.Contains(device.Shell("adb shell dumpsys input_method | grep mInputShown"), "mInputShown=true")
+}
+
+// HideKeyboard closes the soft keyboard, if shown
+func (device *Device) HideKeyboard() {
+	if !device.IsKeyboardShown() {
+		return
+	}
+	if device.Log {
+		log.Println("hiding keyboard")
+	}
+	device.Shell(device.input("keyevent KEYCODE_BACK"))
+}
@@ -0,0 +1,115 @@
+package adbtools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeSettings fakes adb with settings kept as files in a directory,
+// named after their namespace and key, such as system.user_rotation.
+// Putting the read_only key is silently ignored
+func fakeSettings(t *testing.T, settings map[string]string) string {
+	dir := t.TempDir()
+	for name, value := range settings {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the device shell parses the joined adb shell arguments again
+	fakeADB(t, `[ "$1" = shell ] && shift
+eval "set -- $*"
+case "$1 $2" in
+"settings get") cat "`+dir+`/$3.$4" 2>/dev/null || echo null;;
+"settings put") [ "$4" = read_only ] || printf '%s' "$5" > "`+dir+`/$3.$4";;
+"settings delete") rm -f "`+dir+`/$3.$4";;
+esac`)
+	return dir
+}
+
+func readSettings(t *testing.T, dir string) map[string]string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := map[string]string{}
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		settings[entry.Name()] = string(content)
+	}
+	return settings
+}
+
+func TestPrepareForTesting(t *testing.T) {
+	original := map[string]string{
+		"global.window_animation_scale":   "1.0",
+		"global.stay_on_while_plugged_in": "0",
+		"system.accelerometer_rotation":   "1",
+		"system.screen_off_timeout":       "60000",
+		"secure.autofill_service":         "com.google.android.gms/.autofill.service.AutofillService",
+	}
+	dir := fakeSettings(t, original)
+	device := &Device{}
+	snapshot := device.SnapshotSettings()
+	restore, err := device.PrepareForTesting()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prepared := readSettings(t, dir)
+	want := map[string]string{
+		"global.window_animation_scale":      "0",
+		"global.transition_animation_scale":  "0",
+		"global.animator_duration_scale":     "0",
+		"global.stay_on_while_plugged_in":    "7",
+		"system.user_rotation":               "0",
+		"system.accelerometer_rotation":      "0",
+		"system.screen_off_timeout":          "1800000",
+		"secure.spell_checker_enabled":       "0",
+		"secure.autofill_service":            "null",
+		"secure.show_ime_with_hard_keyboard": "0",
+	}
+	for name, value := range want {
+		if prepared[name] != value {
+			t.Errorf("%s = %q; want %q", name, prepared[name], value)
+		}
+	}
+
+	restore()
+	if restored := readSettings(t, dir); fmt.Sprint(restored) != fmt.Sprint(original) {
+		t.Errorf("settings after restore = %v; want %v", restored, original)
+	}
+
+	// the snapshot recorded every setting put, so it restores them as well
+	if _, err := device.PrepareForTesting(); err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if restored := readSettings(t, dir); fmt.Sprint(restored) != fmt.Sprint(original) {
+		t.Errorf("settings after snapshot restore = %v; want %v", restored, original)
+	}
+}
+
+func TestIsKeyboardShown(t *testing.T) {
+	tests := []struct {
+		output string
+		want   bool
+	}{
+		{"  mShowRequested=true mShowExplicitlyRequested=false mShowForced=false mInputShown=true", true},
+		{"  mShowRequested=false mShowExplicitlyRequested=false mShowForced=false mInputShown=false", false},
+		{"  mInputShown=false mShowRequested=true", false},
+		{"Can't find service: input_method", false},
+	}
+	for _, test := range tests {
+		fakeADB(t, fmt.Sprintf("echo %q", test.output))
+		if got := (&Device{}).IsKeyboardShown(); got != test.want {
+			t.Errorf("IsKeyboardShown with %q = %v; want %v", test.output, got, test.want)
+		}
+	}
+}
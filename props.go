@@ -0,0 +1,129 @@
+package adbtools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Props returns every system property
+func (device *Device) Props() (map[string]string, error) {
+	output := device.Shell("adb shell getprop")
+	props := parseProps(output)
+	if len(props) == 0 {
+		return nil, fmt.Errorf("Failed to fetch system properties; output: %s", output)
+	}
+	return props, nil
+}
+
+// Prop returns the value of a single system property; unset properties are empty
+func (device *Device) Prop(name string) string {
+	return strings.TrimSpace(device.Shell("adb shell getprop " + name))
+}
+
+// SetProp sets a system property.
+// Most properties require a debuggable device running adb as root; see Root
+func (device *Device) SetProp(name, value string) error {
+	if device.Log {
+		log.Printf("setting %s property to '%s'", name, value)
+	}
+	output, err := device.command(context.Background(), "shell", "setprop", name, shellQuote(value)).CombinedOutput()
+	if err != nil || len(strings.TrimSpace(string(output))) > 0 {
+		return fmt.Errorf("Failed to set %s property: %v; output: %s", name, err, output)
+	}
+	if current := device.Prop(name); current != value {
+		return fmt.Errorf("Failed to set %s property; current value: '%s'", name, current)
+	}
+	return nil
+}
+
+// WaitProp waits until the property has the wanted value.
+// It requires a max retry count to avoid endless loop.
+func (device *Device) WaitProp(name, value string, attemptCount int) error {
+	attempts := attemptCount
+	for device.Prop(name) != value {
+		attempts--
+		if attempts <= 0 {
+			return fmt.Errorf("reached max retry attempts of %d waiting %s to be '%s'", attemptCount, name, value)
+		}
+		if device.Log {
+			log.Printf("waiting %s to be '%s'; %d attempts left", name, value, attempts)
+		}
+		if err := device.wait(10); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SDK returns the API level, such as 30 for Android 11
+func (device *Device) SDK() (int, error) {
+	output := device.Prop("ro.build.version.sdk")
+	sdk, err := strconv.Atoi(output)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to fetch the sdk version; output: %s", output)
+	}
+	return sdk, nil
+}
+
+// Release returns the Android version, such as 11
+func (device *Device) Release() string {
+	return device.Prop("ro.build.version.release")
+}
+
+// Manufacturer returns the device manufacturer
+func (device *Device) Manufacturer() string {
+	return device.Prop("ro.product.manufacturer")
+}
+
+// Model returns the device model
+func (device *Device) Model() string {
+	return device.Prop("ro.product.model")
+}
+
+// ABIs returns the supported ABIs, the preferred one first
+func (device *Device) ABIs() []string {
+	abis := device.Prop("ro.product.cpu.abilist")
+	if len(abis) == 0 {
+		// devices older than Android 5 only have the primary ABI
+		abis = device.Prop("ro.product.cpu.abi")
+	}
+	if len(abis) == 0 {
+		return []string{}
+	}
+	return strings.Split(abis, ",")
+}
+
+// Fingerprint returns the build fingerprint
+func (device *Device) Fingerprint() string {
+	return device.Prop("ro.build.fingerprint")
+}
+
+// BuildType returns the build type: user, userdebug or eng
+func (device *Device) BuildType() string {
+	return device.Prop("ro.build.type")
+}
+
+// IsEmulator verifies if the device is an emulator
+func (device *Device) IsEmulator() bool {
+	props, err := device.Props()
+	if err != nil {
+		log.Printf("Props err: %v", err)
+		return false
+	}
+	return isEmulator(props)
+}
+
+func isEmulator(props map[string]string) bool {
+	if props["ro.kernel.qemu"] == "1" || props["ro.boot.qemu"] == "1" {
+		return true
+	}
+	switch props["ro.hardware"] {
+	case "goldfish", "ranchu", "vbox86", "cheets":
+		return true
+	}
+	return strings.HasPrefix(props["ro.product.model"], "sdk_") ||
+		strings.Contains(props["ro.product.model"], "Android SDK built for")
+}
@@ -0,0 +1,46 @@
+package adbtools
+
+import "testing"
+
+func TestIsEmulator(t *testing.T) {
+	tests := []struct {
+		props map[string]string
+		want  bool
+	}{
+		{map[string]string{"ro.kernel.qemu": "1"}, true},
+		{map[string]string{"ro.hardware": "ranchu", "ro.product.model": "sdk_gphone_x86"}, true},
+		{map[string]string{"ro.product.model": "Android SDK built for x86"}, true},
+		{map[string]string{"ro.hardware": "qcom", "ro.product.model": "Pixel 4"}, false},
+	}
+	for _, test := range tests {
+		if got := isEmulator(test.props); got != test.want {
+			t.Errorf("isEmulator(%v) = %v; want %v", test.props, got, test.want)
+		}
+	}
+}
+
+func TestParseProps(t *testing.T) {
+	props := parseProps("[ro.build.version.sdk]: [30]\n[ro.product.cpu.abilist]: [x86,armeabi-v7a]\n[persist.sys.empty]: []\n")
+	if props["ro.build.version.sdk"] != "30" || props["ro.product.cpu.abilist"] != "x86,armeabi-v7a" || len(props) != 3 {
+		t.Errorf("unexpected props: %v", props)
+	}
+}
+
+func TestSetPropQuoting(t *testing.T) {
+	dir := t.TempDir()
+	// the device shell parses the joined adb shell arguments again
+	fakeADB(t, `[ "$1" = shell ] && shift
+eval "set -- $*"
+case "$1" in
+setprop) printf '%s' "$3" > "`+dir+`/$2";;
+getprop) cat "`+dir+`/$2" 2>/dev/null;;
+esac`)
+	device := &Device{}
+	value := "it's $HOME and more"
+	if err := device.SetProp("debug.example.label", value); err != nil {
+		t.Fatal(err)
+	}
+	if got := device.Prop("debug.example.label"); got != value {
+		t.Errorf("Prop = %q; want %q", got, value)
+	}
+}
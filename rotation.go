@@ -0,0 +1,145 @@
+package adbtools
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+)
+
+// Rotation is the display rotation in degrees, clockwise from the natural orientation
+type Rotation int
+
+// Display rotations
+const (
+	Rotation0   Rotation = 0
+	Rotation90  Rotation = 90
+	Rotation180 Rotation = 180
+	Rotation270 Rotation = 270
+)
+
+var (
+	// newer releases print the rotation in degrees, older ones print its index
+	rotationDegrees = regexp.MustCompile(`m(?:Current)?Rotation=ROTATION_(0|90|180|270)\b`)
+	rotationIndex   = regexp.MustCompile(`m(?:Current)?Rotation=([0-3])\b`)
+	windowDisplay   = regexp.MustCompile(`Display: mDisplayId=(\d+)`)
+)
+
+// index returns the surface rotation index used by the Android settings, from 0 to 3
+func (rotation Rotation) index() int {
+	return int(rotation) / 90
+}
+
+func (rotation Rotation) valid() bool {
+	switch rotation {
+	case Rotation0, Rotation90, Rotation180, Rotation270:
+		return true
+	}
+	return false
+}
+
+// Rotation returns the current rotation of the display selected by device.DisplayID
+func (device *Device) Rotation() (Rotation, error) {
+	output := device.Shell("adb shell dumpsys window displays")
+	if rotation, ok := parseRotation(windowDisplaySection(output, device.DisplayID)); ok {
+		return rotation, nil
+	}
+	if rotation, ok := parseViewportRotation(device.Shell("adb shell dumpsys display"), device.DisplayID); ok {
+		return rotation, nil
+	}
+	return 0, fmt.Errorf("Failed to fetch the display %d rotation", device.DisplayID)
+}
+
+// SetRotation disables auto-rotate and locks the display in the given rotation.
+//
+// Returns a function to be deferred setting the previous rotation and auto-rotate back
+func (device *Device) SetRotation(rotation Rotation) (func(), error) {
+	if !rotation.valid() {
+		return func() {}, fmt.Errorf("invalid rotation %d; must be 0, 90, 180 or 270", rotation)
+	}
+	if device.Log {
+		log.Printf("rotating to %d degrees", rotation)
+	}
+	resetAutoRotate, err := device.PutSetting(SettingsSystem, "accelerometer_rotation", "0")
+	if err != nil {
+		return func() {}, err
+	}
+	resetRotation, err := device.PutSetting(SettingsSystem, "user_rotation", strconv.Itoa(rotation.index()))
+	if err != nil {
+		resetAutoRotate()
+		return func() {}, err
+	}
+	restore := func() {
+		resetRotation()
+		resetAutoRotate()
+	}
+	for attempts := 10; attempts > 0; attempts-- {
+		current, err := device.Rotation()
+		if err == nil && current == rotation {
+			return restore, nil
+		}
+		device.sleep(5)
+	}
+	restore()
+	return func() {}, fmt.Errorf("Failed to rotate to %d degrees; the app may have locked its orientation", rotation)
+}
+
+// Portrait locks the display in its natural portrait orientation
+//
+// Returns a function to be deferred setting the previous rotation back
+func (device *Device) Portrait() (func(), error) {
+	return device.SetRotation(Rotation0)
+}
+
+// Landscape locks the display rotated to landscape
+//
+// Returns a function to be deferred setting the previous rotation back
+func (device *Device) Landscape() (func(), error) {
+	return device.SetRotation(Rotation90)
+}
+
+func parseRotation(output string) (Rotation, bool) {
+	if matches := rotationDegrees.FindStringSubmatch(output); len(matches) > 0 {
+		degrees, _ := strconv.Atoi(matches[1])
+		return Rotation(degrees), true
+	}
+	if matches := rotationIndex.FindStringSubmatch(output); len(matches) > 0 {
+		index, _ := strconv.Atoi(matches[1])
+		return Rotation(index * 90), true
+	}
+	return 0, false
+}
+
+// windowDisplaySection returns the part of dumpsys window displays about the display;
+// releases without per display sections only describe the default display
+func windowDisplaySection(output string, id int) string {
+	sections := windowDisplay.FindAllStringSubmatchIndex(output, -1)
+	if len(sections) == 0 {
+		if id == 0 {
+			return output
+		}
+		return ""
+	}
+	for i, section := range sections {
+		if output[section[2]:section[3]] != strconv.Itoa(id) {
+			continue
+		}
+		end := len(output)
+		if i+1 < len(sections) {
+			end = sections[i+1][0]
+		}
+		return output[section[0]:end]
+	}
+	return ""
+}
+
+// parseViewportRotation reads the display orientation from its dumpsys display viewport
+func parseViewportRotation(output string, id int) (Rotation, bool) {
+	viewport := regexp.MustCompile(fmt.Sprintf(`DisplayViewport\{[^}]*displayId=%d\b[^}]*orientation=([0-3])`, id))
+	matches := viewport.FindStringSubmatch(output)
+	if len(matches) == 0 {
+		return 0, false
+	}
+	index, _ := strconv.Atoi(matches[1])
+	return Rotation(index * 90), true
+}
@@ -0,0 +1,49 @@
+package adbtools
+
+import "testing"
+
+func TestParseRotation(t *testing.T) {
+	tests := []struct {
+		output string
+		want   Rotation
+		ok     bool
+	}{
+		{"  mDisplayId=0 mCurrentRotation=ROTATION_270 mLastOrientation=0", Rotation270, true},
+		{"  mRotation=1 mAltOrientation=false", Rotation90, true},
+		{"  mCurrentRotation=2", Rotation180, true},
+		{"WINDOW MANAGER DISPLAY CONTENTS", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := parseRotation(test.output)
+		if got != test.want || ok != test.ok {
+			t.Errorf("parseRotation(%q) = %d, %v; want %d, %v", test.output, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestDisplayRotation(t *testing.T) {
+	windows := `WINDOW MANAGER DISPLAY CONTENTS (dumpsys window displays)
+  Display: mDisplayId=0 rootTasks=2
+    mRotation=0 mAltOrientation=false
+  Display: mDisplayId=2 rootTasks=1
+    mRotation=1 mAltOrientation=false
+`
+	if got, ok := parseRotation(windowDisplaySection(windows, 2)); got != Rotation90 || !ok {
+		t.Errorf("display 2 rotation = %d, %v; want %d", got, ok, Rotation90)
+	}
+	if got, ok := parseRotation(windowDisplaySection(windows, 0)); got != Rotation0 || !ok {
+		t.Errorf("display 0 rotation = %d, %v; want %d", got, ok, Rotation0)
+	}
+	if _, ok := parseRotation(windowDisplaySection(windows, 5)); ok {
+		t.Error("found the rotation of a missing display")
+	}
+
+	viewports := "mViewports=[DisplayViewport{type=INTERNAL, valid=true, displayId=0, orientation=0}, " +
+		"DisplayViewport{type=EXTERNAL, valid=true, displayId=12, orientation=3}]"
+	if got, ok := parseViewportRotation(viewports, 12); got != Rotation270 || !ok {
+		t.Errorf("parseViewportRotation(12) = %d, %v; want %d", got, ok, Rotation270)
+	}
+	if _, ok := parseViewportRotation(viewports, 1); ok {
+		t.Error("parseViewportRotation matched displayId=12 for display 1")
+	}
+}
@@ -0,0 +1,281 @@
+package adbtools
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Prefs holds the values of a SharedPreferences file.
+//
+// Values are string, int32, int64, bool, float32 or []string for string sets;
+// int values are written as int32
+type Prefs map[string]interface{}
+
+type prefsXML struct {
+	Entries []prefEntry `xml:",any"`
+}
+
+type prefEntry struct {
+	XMLName xml.Name
+	Name    string   `xml:"name,attr"`
+	Value   string   `xml:"value,attr"`
+	Text    string   `xml:",chardata"`
+	Items   []string `xml:"string"`
+}
+
+// RunAs runs the command as the debuggable package user, inside its data directory
+func (device *Device) RunAs(pkg, command string) (string, error) {
+	cmd := device.command(context.Background(), "shell", fmt.Sprintf("run-as %s %s", pkg, command))
+	output, err := cmd.CombinedOutput()
+	if err != nil || strings.HasPrefix(string(output), "run-as:") {
+		return string(output), fmt.Errorf("Failed to run '%s' as %s: %v; output: %s", command, pkg, err, output)
+	}
+	return string(output), nil
+}
+
+// PullDatabase copies the named database of the package into dir, along with
+// its -wal, -shm and -journal files, so it opens with the latest writes.
+//
+// Returns the local paths of the copied files
+func (device *Device) PullDatabase(pkg, name, dir string) ([]string, error) {
+	files, err := device.databaseFiles(pkg)
+	if err != nil {
+		return nil, err
+	}
+	pulled := []string{}
+	for _, file := range files {
+		if databaseName(file) != name {
+			continue
+		}
+		local, err := device.pullDatabaseFile(pkg, file, dir)
+		if err != nil {
+			return pulled, err
+		}
+		if len(local) > 0 {
+			pulled = append(pulled, local)
+		}
+	}
+	if len(pulled) == 0 {
+		return nil, fmt.Errorf("database %s not found in %s", name, pkg)
+	}
+	return pulled, nil
+}
+
+// PullDatabases copies every database of the package into dir,
+// along with their -wal, -shm and -journal files.
+//
+// Returns the local paths of the copied files
+func (device *Device) PullDatabases(pkg, dir string) ([]string, error) {
+	files, err := device.databaseFiles(pkg)
+	if err != nil {
+		return nil, err
+	}
+	pulled := []string{}
+	for _, file := range files {
+		local, err := device.pullDatabaseFile(pkg, file, dir)
+		if err != nil {
+			return pulled, err
+		}
+		if len(local) > 0 {
+			pulled = append(pulled, local)
+		}
+	}
+	return pulled, nil
+}
+
+// ReadPrefs reads the named SharedPreferences file of the package; name has no .xml extension
+func (device *Device) ReadPrefs(pkg, name string) (Prefs, error) {
+	output, err := device.RunAs(pkg, fmt.Sprintf("cat shared_prefs/%s.xml", name))
+	if err != nil {
+		return nil, err
+	}
+	return parsePrefs([]byte(output))
+}
+
+// WritePrefs replaces the named SharedPreferences file of the package.
+//
+// The app is stopped first, since it keeps the preferences in memory
+// and would overwrite the file; the new values are loaded on its next start
+func (device *Device) WritePrefs(pkg, name string, prefs Prefs) error {
+	content, err := encodePrefs(prefs)
+	if err != nil {
+		return err
+	}
+	device.CloseApp(pkg)
+	if device.Log {
+		log.Printf("writing %s shared_prefs/%s.xml", pkg, name)
+	}
+	if _, err := device.RunAs(pkg, "mkdir -p shared_prefs"); err != nil {
+		return err
+	}
+	cmd := device.command(context.Background(), "shell", fmt.Sprintf("run-as %s sh -c 'cat > shared_prefs/%s.xml'", pkg, name))
+	cmd.Stdin = bytes.NewReader(content)
+	if output, err := cmd.CombinedOutput(); err != nil || len(bytes.TrimSpace(output)) > 0 {
+		return fmt.Errorf("Failed to write %s shared_prefs/%s.xml: %v; output: %s", pkg, name, err, output)
+	}
+	return nil
+}
+
+// SetPrefs updates some values of the named SharedPreferences file, keeping the others
+func (device *Device) SetPrefs(pkg, name string, values Prefs) error {
+	prefs, err := device.ReadPrefs(pkg, name)
+	if err != nil {
+		if !strings.Contains(err.Error(), "No such file") {
+			return err
+		}
+		prefs = Prefs{}
+	}
+	for key, value := range values {
+		prefs[key] = value
+	}
+	return device.WritePrefs(pkg, name, prefs)
+}
+
+func (device *Device) databaseFiles(pkg string) ([]string, error) {
+	output, err := device.RunAs(pkg, "ls databases")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(output), nil
+}
+
+// databaseName returns the database a -wal, -shm or -journal file belongs to
+func databaseName(file string) string {
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if strings.HasSuffix(file, suffix) {
+			return strings.TrimSuffix(file, suffix)
+		}
+	}
+	return file
+}
+
+// pullDatabaseFile copies a database file into dir and returns its local path.
+// The -wal and -journal files come and go with the transactions, so the
+// ones gone since listing the directory are skipped, returning no path
+func (device *Device) pullDatabaseFile(pkg, file, dir string) (string, error) {
+	local := filepath.Join(dir, file)
+	err := device.pullPrivate(pkg, "databases/"+file, local)
+	if err != nil && databaseName(file) != file && strings.Contains(err.Error(), "No such file") {
+		if device.Log {
+			log.Printf("skipping %s; removed before being pulled", file)
+		}
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return local, nil
+}
+
+// pullPrivate copies a file of the package data directory to the host.
+// With shell_v2 it fails on the cat exit status; older devices report no
+// exit status, so the file is listed first to keep an error message
+// from being saved as its content
+func (device *Device) pullPrivate(pkg, remote, local string) error {
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return fmt.Errorf("os.MkdirAll err: %v", err)
+	}
+	if device.Log {
+		log.Printf("pulling %s %s to %s", pkg, remote, local)
+	}
+	service := device.binaryShell()
+	if service == "exec-out" {
+		output := device.Shell(fmt.Sprintf("adb shell run-as %s ls %s", pkg, remote))
+		if strings.Contains(output, "No such file") || strings.Contains(output, "run-as:") {
+			return fmt.Errorf("Failed to pull %s %s; output: %s", pkg, remote, output)
+		}
+	}
+	return device.capture(local, service, fmt.Sprintf("run-as %s cat %s", pkg, remote))
+}
+
+func parsePrefs(content []byte) (Prefs, error) {
+	parsed := prefsXML{}
+	if err := xml.Unmarshal(content, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid shared preferences: %v", err)
+	}
+	prefs := Prefs{}
+	for _, entry := range parsed.Entries {
+		var err error
+		switch entry.XMLName.Local {
+		case "string":
+			prefs[entry.Name] = entry.Text
+		case "int":
+			var value int64
+			value, err = strconv.ParseInt(entry.Value, 10, 32)
+			prefs[entry.Name] = int32(value)
+		case "long":
+			prefs[entry.Name], err = strconv.ParseInt(entry.Value, 10, 64)
+		case "boolean":
+			prefs[entry.Name], err = strconv.ParseBool(entry.Value)
+		case "float":
+			var value float64
+			value, err = strconv.ParseFloat(entry.Value, 32)
+			prefs[entry.Name] = float32(value)
+		case "set":
+			items := []string{}
+			prefs[entry.Name] = append(items, entry.Items...)
+		case "null":
+			prefs[entry.Name] = nil
+		default:
+			err = fmt.Errorf("unknown type")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s preference %s: %v", entry.XMLName.Local, entry.Name, err)
+		}
+	}
+	return prefs, nil
+}
+
+func encodePrefs(prefs Prefs) ([]byte, error) {
+	keys := []string{}
+	for key := range prefs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buffer := &bytes.Buffer{}
+	buffer.WriteString("<?xml version='1.0' encoding='utf-8' standalone='yes' ?>\n<map>\n")
+	for _, key := range keys {
+		name := escapeXML(key)
+		switch value := prefs[key].(type) {
+		case string:
+			fmt.Fprintf(buffer, "    <string name=\"%s\">%s</string>\n", name, escapeXML(value))
+		case int32:
+			fmt.Fprintf(buffer, "    <int name=\"%s\" value=\"%d\" />\n", name, value)
+		case int:
+			fmt.Fprintf(buffer, "    <int name=\"%s\" value=\"%d\" />\n", name, value)
+		case int64:
+			fmt.Fprintf(buffer, "    <long name=\"%s\" value=\"%d\" />\n", name, value)
+		case bool:
+			fmt.Fprintf(buffer, "    <boolean name=\"%s\" value=\"%t\" />\n", name, value)
+		case float32:
+			fmt.Fprintf(buffer, "    <float name=\"%s\" value=\"%s\" />\n", name, strconv.FormatFloat(float64(value), 'f', -1, 32))
+		case []string:
+			fmt.Fprintf(buffer, "    <set name=\"%s\">\n", name)
+			for _, item := range value {
+				fmt.Fprintf(buffer, "        <string>%s</string>\n", escapeXML(item))
+			}
+			buffer.WriteString("    </set>\n")
+		case nil:
+			fmt.Fprintf(buffer, "    <null name=\"%s\" />\n", name)
+		default:
+			return nil, fmt.Errorf("invalid preference %s: unsupported type %T", key, value)
+		}
+	}
+	buffer.WriteString("</map>\n")
+	return buffer.Bytes(), nil
+}
+
+func escapeXML(text string) string {
+	buffer := &bytes.Buffer{}
+	xml.EscapeText(buffer, []byte(text))
+	return buffer.String()
+}
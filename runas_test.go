@@ -0,0 +1,127 @@
+package adbtools
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const sharedPrefs = `<?xml version='1.0' encoding='utf-8' standalone='yes' ?>
+<map>
+    <string name="user">ana &amp; bob</string>
+    <int name="launches" value="3" />
+    <long name="installed_at" value="1792343401000" />
+    <boolean name="onboarding_done" value="true" />
+    <float name="ratio" value="1.5" />
+    <set name="flags">
+        <string>new_home</string>
+        <string>dark_mode</string>
+    </set>
+    <null name="token" />
+</map>
+`
+
+func TestParsePrefs(t *testing.T) {
+	want := Prefs{
+		"user":            "ana & bob",
+		"launches":        int32(3),
+		"installed_at":    int64(1792343401000),
+		"onboarding_done": true,
+		"ratio":           float32(1.5),
+		"flags":           []string{"new_home", "dark_mode"},
+		"token":           nil,
+	}
+	got, err := parsePrefs([]byte(sharedPrefs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePrefs = %v; want %v", got, want)
+	}
+
+	encoded, err := encodePrefs(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := parsePrefs(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("parsePrefs(encodePrefs) = %v; want %v", decoded, want)
+	}
+
+	if _, err := encodePrefs(Prefs{"when": 1.5}); err == nil {
+		t.Error("encodePrefs accepted a float64")
+	}
+	if _, err := parsePrefs([]byte(`<map><int name="n" value="x" /></map>`)); err == nil {
+		t.Error("parsePrefs accepted an invalid int")
+	}
+}
+
+func TestDatabaseName(t *testing.T) {
+	for file, want := range map[string]string{
+		"app.db":         "app.db",
+		"app.db-wal":     "app.db",
+		"app.db-shm":     "app.db",
+		"app.db-journal": "app.db",
+	} {
+		if got := databaseName(file); got != want {
+			t.Errorf("databaseName(%s) = %s; want %s", file, got, want)
+		}
+	}
+}
+
+func TestPullDatabaseSkipsVanishedJournal(t *testing.T) {
+	fakeADB(t, `case "$*" in
+features) echo shell_v2;;
+*"ls databases"*) echo "app.db app.db-journal other.db";;
+*"cat databases/app.db-journal"*) echo "cat: databases/app.db-journal: No such file or directory" >&2; exit 1;;
+*"cat databases/app.db"*) printf 'SQLite format 3';;
+esac`)
+	dir := t.TempDir()
+	device := &Device{}
+	pulled, err := device.PullDatabase("com.example.app", "app.db", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "app.db")}; !reflect.DeepEqual(pulled, want) {
+		t.Errorf("PullDatabase = %v; want %v", pulled, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app.db-journal")); !os.IsNotExist(err) {
+		t.Error("the vanished journal left a local file behind")
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "app.db"))
+	if err != nil || string(content) != "SQLite format 3" {
+		t.Errorf("app.db = %q, %v", content, err)
+	}
+}
+
+func TestPullDatabaseWithoutShellV2(t *testing.T) {
+	// exec-out has no exit status and mixes the cat errors into the content
+	fakeADB(t, `case "$*" in
+features) echo fixed_push_mkdir;;
+*"ls databases/app.db-journal"*) echo "databases/app.db-journal: No such file or directory";;
+*"ls databases/app.db"*) echo "databases/app.db";;
+*"ls databases"*) echo "app.db app.db-journal";;
+"exec-out run-as com.example.app cat databases/app.db-journal") echo "cat: databases/app.db-journal: No such file or directory";;
+"exec-out run-as com.example.app cat databases/app.db") printf 'SQLite format 3\n\000';;
+esac`)
+	dir := t.TempDir()
+	device := &Device{}
+	pulled, err := device.PullDatabase("com.example.app", "app.db", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "app.db")}; !reflect.DeepEqual(pulled, want) {
+		t.Errorf("PullDatabase = %v; want %v", pulled, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app.db-journal")); !os.IsNotExist(err) {
+		t.Error("the vanished journal was saved with the cat error")
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "app.db"))
+	if err != nil || string(content) != "SQLite format 3\n\000" {
+		t.Errorf("app.db = %q, %v", content, err)
+	}
+}
@@ -0,0 +1,172 @@
+package adbtools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Settings namespaces
+const (
+	SettingsSystem = "system"
+	SettingsSecure = "secure"
+	SettingsGlobal = "global"
+)
+
+// SettingsSnapshot records the original value of every setting
+// put on the device while active, so all of them can be rolled back at once
+type SettingsSnapshot struct {
+	device   *Device
+	mu       sync.Mutex
+	original []settingValue
+}
+
+type settingValue struct {
+	namespace string
+	key       string
+	value     string
+	unset     bool
+}
+
+// Setting returns the value of a system, secure or global setting.
+//
+// The returned bool tells if the setting is set at all
+func (device *Device) Setting(namespace, key string) (string, bool, error) {
+	if err := validNamespace(namespace); err != nil {
+		return "", false, err
+	}
+	output := strings.TrimSpace(device.Shell(fmt.Sprintf("adb shell settings get %s %s", namespace, key)))
+	if strings.Contains(output, "Exception") || strings.HasPrefix(output, "shell.Cmd err") {
+		return "", false, fmt.Errorf("Failed to get %s %s setting; output: %s", namespace, key, output)
+	}
+	if output == "null" {
+		return "", false, nil
+	}
+	return output, true, nil
+}
+
+// PutSetting sets a system, secure or global setting.
+//
+// Returns a function to be deferred setting the previous value back,
+// or deleting the setting when it was unset
+func (device *Device) PutSetting(namespace, key, value string) (func(), error) {
+	current, set, err := device.Setting(namespace, key)
+	if err != nil {
+		return func() {}, err
+	}
+	if set && current == value {
+		return func() {}, nil
+	}
+	var snapshot *SettingsSnapshot
+	device.locked(func() { snapshot = device.settings })
+	if snapshot != nil {
+		snapshot.record(settingValue{namespace: namespace, key: key, value: current, unset: !set})
+	}
+
+	if device.Log {
+		log.Printf("setting %s %s to '%s'", namespace, key, value)
+	}
+	if err := device.putSetting(namespace, key, value); err != nil {
+		return func() {}, err
+	}
+	return func() {
+		if device.Log {
+			log.Printf("setting %s %s back to '%s'", namespace, key, current)
+		}
+		if err := (settingValue{namespace: namespace, key: key, value: current, unset: !set}).restore(device); err != nil {
+			log.Print(err)
+		}
+	}, nil
+}
+
+// DeleteSetting removes a system, secure or global setting
+func (device *Device) DeleteSetting(namespace, key string) error {
+	if err := validNamespace(namespace); err != nil {
+		return err
+	}
+	output := device.Shell(fmt.Sprintf("adb shell settings delete %s %s", namespace, key))
+	if strings.Contains(output, "Exception") {
+		return fmt.Errorf("Failed to delete %s %s setting; output: %s", namespace, key, output)
+	}
+	return nil
+}
+
+// SnapshotSettings starts recording every setting put through PutSetting,
+// including the ones put by other methods such as ScreenTimeout.
+//
+// Call Restore, usually deferred, to roll all of them back
+func (device *Device) SnapshotSettings() *SettingsSnapshot {
+	snapshot := &SettingsSnapshot{device: device}
+	device.locked(func() { device.settings = snapshot })
+	return snapshot
+}
+
+// Restore stops recording and sets every recorded setting back
+// to its original value, in the reverse order they were first put
+func (snapshot *SettingsSnapshot) Restore() error {
+	device := snapshot.device
+	device.locked(func() {
+		if device.settings == snapshot {
+			device.settings = nil
+		}
+	})
+	snapshot.mu.Lock()
+	defer snapshot.mu.Unlock()
+	failed := []string{}
+	for i := len(snapshot.original) - 1; i >= 0; i-- {
+		if err := snapshot.original[i].restore(device); err != nil {
+			log.Print(err)
+			failed = append(failed, snapshot.original[i].namespace+" "+snapshot.original[i].key)
+		}
+	}
+	snapshot.original = nil
+	if len(failed) > 0 {
+		return fmt.Errorf("Failed to restore settings: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// record keeps the original value of the first put of each setting
+func (snapshot *SettingsSnapshot) record(original settingValue) {
+	snapshot.mu.Lock()
+	defer snapshot.mu.Unlock()
+	for _, item := range snapshot.original {
+		if item.namespace == original.namespace && item.key == original.key {
+			return
+		}
+	}
+	snapshot.original = append(snapshot.original, original)
+}
+
+func (setting settingValue) restore(device *Device) error {
+	if setting.unset {
+		return device.DeleteSetting(setting.namespace, setting.key)
+	}
+	return device.putSetting(setting.namespace, setting.key, setting.value)
+}
+
+func (device *Device) putSetting(namespace, key, value string) error {
+	output, err := device.command(context.Background(), "shell", "settings", "put", namespace, key, shellQuote(value)).CombinedOutput()
+	if err != nil || len(strings.TrimSpace(string(output))) > 0 {
+		return fmt.Errorf("Failed to set %s %s setting: %v; output: %s", namespace, key, err, output)
+	}
+	// some providers drop invalid values without complaining
+	current, set, err := device.Setting(namespace, key)
+	if err != nil {
+		return err
+	}
+	if current != strings.TrimSpace(value) && !(value == "null" && !set) {
+		return fmt.Errorf("Failed to set %s %s setting to '%s'; it reads '%s'", namespace, key, value, current)
+	}
+	return nil
+}
+
+func validNamespace(namespace string) error {
+	switch namespace {
+	case SettingsSystem, SettingsSecure, SettingsGlobal:
+		return nil
+	}
+	return fmt.Errorf("invalid settings namespace '%s'; must be system, secure or global", namespace)
+}
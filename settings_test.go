@@ -0,0 +1,47 @@
+package adbtools
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSettingsSnapshotRecord(t *testing.T) {
+	snapshot := &SettingsSnapshot{}
+	snapshot.record(settingValue{namespace: SettingsSystem, key: "screen_off_timeout", value: "60000"})
+	snapshot.record(settingValue{namespace: SettingsGlobal, key: "stay_on_while_plugged_in", unset: true})
+	// only the value before the first put is the original one
+	snapshot.record(settingValue{namespace: SettingsSystem, key: "screen_off_timeout", value: "1800000"})
+	want := []settingValue{
+		{namespace: SettingsSystem, key: "screen_off_timeout", value: "60000"},
+		{namespace: SettingsGlobal, key: "stay_on_while_plugged_in", unset: true},
+	}
+	if !reflect.DeepEqual(snapshot.original, want) {
+		t.Errorf("unexpected recorded settings: %+v", snapshot.original)
+	}
+}
+
+func TestValidNamespace(t *testing.T) {
+	for _, namespace := range []string{SettingsSystem, SettingsSecure, SettingsGlobal} {
+		if err := validNamespace(namespace); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := validNamespace("config"); err == nil {
+		t.Error("expected config namespace to be invalid")
+	}
+}
+
+func TestPutSettingQuoting(t *testing.T) {
+	dir := fakeSettings(t, map[string]string{})
+	device := &Device{}
+	value := "com.example/.Service:it's $HOME"
+	if _, err := device.PutSetting(SettingsSecure, "enabled_accessibility_services", value); err != nil {
+		t.Fatal(err)
+	}
+	if got := readSettings(t, dir)["secure.enabled_accessibility_services"]; got != value {
+		t.Errorf("stored %q; want %q", got, value)
+	}
+	if _, err := device.PutSetting(SettingsGlobal, "read_only", "1"); err == nil {
+		t.Error("PutSetting ignored a value the device did not store")
+	}
+}
@@ -0,0 +1,452 @@
+package adbtools
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// syncChunk is the largest DATA payload accepted by the adb daemon
+const syncChunk = 64 * 1024
+
+// syncConn is a connection to the device in SYNC mode
+type syncConn struct {
+	conn   net.Conn
+	cancel context.CancelFunc
+}
+
+// remoteFile describes a file on the device as reported by STAT and LIST
+type remoteFile struct {
+	name  string
+	mode  uint32
+	size  uint32
+	mtime uint32
+}
+
+func (file remoteFile) Name() string       { return file.name }
+func (file remoteFile) Size() int64        { return int64(file.size) }
+func (file remoteFile) Mode() fs.FileMode  { return unixMode(file.mode) }
+func (file remoteFile) ModTime() time.Time { return time.Unix(int64(file.mtime), 0) }
+func (file remoteFile) IsDir() bool        { return file.Mode().IsDir() }
+func (file remoteFile) Sys() interface{}   { return nil }
+
+// Stat returns the mode, size and modification time of a file on the device
+func (device *Device) Stat(remote string) (fs.FileInfo, error) {
+	session, err := device.openSync()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	return session.stat(remote)
+}
+
+// ReadDir lists a directory on the device, sorted by name
+func (device *Device) ReadDir(remote string) ([]fs.FileInfo, error) {
+	session, err := device.openSync()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	return session.list(remote)
+}
+
+// ReadFile streams a file on the device into w
+func (device *Device) ReadFile(remote string, w io.Writer) error {
+	session, err := device.openSync()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return session.recv(remote, w)
+}
+
+// WriteFile streams r into a file on the device, creating or truncating it
+// with the given permissions and modification time
+func (device *Device) WriteFile(remote string, r io.Reader, mode fs.FileMode, mtime time.Time) error {
+	session, err := device.openSync()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	return session.send(remote, r, mode, mtime)
+}
+
+// Push copies a local file or directory to the device, keeping the
+// modification times. Directories are copied recursively, and pushing
+// into an existing remote directory copies into it, as adb push does.
+//
+// A zero mode keeps the local permissions
+func (device *Device) Push(local, remote string, mode fs.FileMode) error {
+	if device.Log {
+		log.Printf("pushing %s to %s", local, remote)
+	}
+	session, err := device.openSync()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	// STAT does not follow links; a trailing slash resolves linked directories such as /sdcard
+	if info, err := session.stat(strings.TrimSuffix(remote, "/") + "/"); err == nil && info.IsDir() {
+		remote = path.Join(remote, filepath.Base(local))
+	}
+	return filepath.Walk(local, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(local, file)
+		if err != nil {
+			return err
+		}
+		target := remote
+		if relative != "." {
+			target = path.Join(remote, filepath.ToSlash(relative))
+		}
+		if info.IsDir() {
+			// SEND creates the directories holding the files; empty ones need mkdir
+			entries, err := os.ReadDir(file)
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				return device.Mkdir(target)
+			}
+			return nil
+		}
+		perm := mode
+		if mode == 0 {
+			perm = info.Mode()
+		}
+		reader, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		return session.send(target, reader, perm, info.ModTime())
+	})
+}
+
+// Pull copies a file or directory from the device to the host, keeping the
+// permissions and modification times. Directories are copied recursively
+func (device *Device) Pull(remote, local string) error {
+	if device.Log {
+		log.Printf("pulling %s to %s", remote, local)
+	}
+	session, err := device.openSync()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	info, err := session.stat(remote)
+	if err != nil {
+		return err
+	}
+	// STAT does not follow links; a trailing slash resolves linked directories such as /sdcard
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if linked, err := session.stat(remote + "/"); err == nil {
+			info = linked
+		}
+	}
+	return session.pull(remote, local, info)
+}
+
+// Remove deletes a file or directory, recursively, from the device
+func (device *Device) Remove(remote string) error {
+	if device.Log {
+		log.Printf("removing %s", remote)
+	}
+	output, err := device.command(context.Background(), "shell", "rm", "-rf", shellQuote(remote)).CombinedOutput()
+	if err != nil || len(strings.TrimSpace(string(output))) > 0 {
+		return fmt.Errorf("Failed to remove %s: %v; output: %s", remote, err, output)
+	}
+	return nil
+}
+
+// Mkdir creates a directory on the device, along with any missing parents
+func (device *Device) Mkdir(remote string) error {
+	if device.Log {
+		log.Printf("creating directory %s", remote)
+	}
+	output, err := device.command(context.Background(), "shell", "mkdir", "-p", shellQuote(remote)).CombinedOutput()
+	if err != nil || len(strings.TrimSpace(string(output))) > 0 {
+		return fmt.Errorf("Failed to create %s: %v; output: %s", remote, err, output)
+	}
+	return nil
+}
+
+// openSync connects to the device through the adb server and switches to SYNC mode
+func (device *Device) openSync() (*syncConn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := dialADB(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	transport := "host:transport-any"
+	if len(device.ID) > 0 {
+		transport = "host:transport:" + device.ID
+	}
+	for _, request := range []string{transport, "sync:"} {
+		if err := sendRequest(conn, request); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	return &syncConn{conn: conn, cancel: cancel}, nil
+}
+
+// Close ends the SYNC session
+func (session *syncConn) Close() error {
+	err := session.request("QUIT", nil)
+	session.cancel()
+	return err
+}
+
+// request sends a SYNC request: its id, the little endian length and the data
+func (session *syncConn) request(id string, data []byte) error {
+	header := make([]byte, 8)
+	copy(header, id)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+	if _, err := session.conn.Write(append(header, data...)); err != nil {
+		return fmt.Errorf("failed to send %s: %v", id, err)
+	}
+	return nil
+}
+
+// response reads a SYNC response id and its length or first field
+func (session *syncConn) response() (string, uint32, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(session.conn, header); err != nil {
+		return "", 0, err
+	}
+	return string(header[:4]), binary.LittleEndian.Uint32(header[4:]), nil
+}
+
+// fail reads the message of a FAIL response
+func (session *syncConn) fail(op, remote string, length uint32) error {
+	message := make([]byte, length)
+	if _, err := io.ReadFull(session.conn, message); err != nil {
+		return err
+	}
+	return &fs.PathError{Op: op, Path: remote, Err: fmt.Errorf("%s", message)}
+}
+
+func (session *syncConn) stat(remote string) (fs.FileInfo, error) {
+	if err := session.request("STAT", []byte(remote)); err != nil {
+		return nil, err
+	}
+	id, mode, err := session.response()
+	if err != nil {
+		return nil, err
+	}
+	if id != "STAT" {
+		return nil, fmt.Errorf("invalid STAT response %q", id)
+	}
+	fields := make([]byte, 8)
+	if _, err := io.ReadFull(session.conn, fields); err != nil {
+		return nil, err
+	}
+	// the daemon reports missing files as all zeros
+	if mode == 0 {
+		return nil, &fs.PathError{Op: "stat", Path: remote, Err: fs.ErrNotExist}
+	}
+	return remoteFile{
+		name:  path.Base(remote),
+		mode:  mode,
+		size:  binary.LittleEndian.Uint32(fields),
+		mtime: binary.LittleEndian.Uint32(fields[4:]),
+	}, nil
+}
+
+func (session *syncConn) list(remote string) ([]fs.FileInfo, error) {
+	if err := session.request("LIST", []byte(remote)); err != nil {
+		return nil, err
+	}
+	files := []fs.FileInfo{}
+	for {
+		id, mode, err := session.response()
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case "DENT":
+		case "DONE":
+			// DONE carries the same fields as DENT, all zeros
+			if _, err := io.CopyN(io.Discard, session.conn, 12); err != nil {
+				return nil, err
+			}
+			sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+			return files, nil
+		case "FAIL":
+			return nil, session.fail("readdir", remote, mode)
+		default:
+			return nil, fmt.Errorf("invalid LIST response %q", id)
+		}
+		fields := make([]byte, 12)
+		if _, err := io.ReadFull(session.conn, fields); err != nil {
+			return nil, err
+		}
+		name := make([]byte, binary.LittleEndian.Uint32(fields[8:]))
+		if _, err := io.ReadFull(session.conn, name); err != nil {
+			return nil, err
+		}
+		if string(name) == "." || string(name) == ".." {
+			continue
+		}
+		files = append(files, remoteFile{
+			name:  string(name),
+			mode:  mode,
+			size:  binary.LittleEndian.Uint32(fields),
+			mtime: binary.LittleEndian.Uint32(fields[4:]),
+		})
+	}
+}
+
+func (session *syncConn) recv(remote string, w io.Writer) error {
+	if err := session.request("RECV", []byte(remote)); err != nil {
+		return err
+	}
+	for {
+		id, length, err := session.response()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case "DATA":
+			if _, err := io.CopyN(w, session.conn, int64(length)); err != nil {
+				return err
+			}
+		case "DONE":
+			return nil
+		case "FAIL":
+			return session.fail("read", remote, length)
+		default:
+			return fmt.Errorf("invalid RECV response %q", id)
+		}
+	}
+}
+
+func (session *syncConn) send(remote string, r io.Reader, mode fs.FileMode, mtime time.Time) error {
+	if err := session.request("SEND", []byte(fmt.Sprintf("%s,%d", remote, 0100000|unixPerm(mode)))); err != nil {
+		return err
+	}
+	buffer := make([]byte, syncChunk)
+	for {
+		n, err := io.ReadFull(r, buffer)
+		if n > 0 {
+			if err := session.request("DATA", buffer[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	done := make([]byte, 8)
+	copy(done, "DONE")
+	binary.LittleEndian.PutUint32(done[4:], uint32(mtime.Unix()))
+	if _, err := session.conn.Write(done); err != nil {
+		return fmt.Errorf("failed to send DONE: %v", err)
+	}
+	id, length, err := session.response()
+	if err != nil {
+		return err
+	}
+	switch id {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		return session.fail("write", remote, length)
+	}
+	return fmt.Errorf("invalid SEND response %q", id)
+}
+
+func (session *syncConn) pull(remote, local string, info fs.FileInfo) error {
+	if info.IsDir() {
+		if err := os.MkdirAll(local, info.Mode().Perm()|0700); err != nil {
+			return err
+		}
+		files, err := session.list(remote)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := session.pull(path.Join(remote, file.Name()), filepath.Join(local, file.Name()), file); err != nil {
+				return err
+			}
+		}
+		return os.Chtimes(local, info.ModTime(), info.ModTime())
+	}
+	if !info.Mode().IsRegular() {
+		log.Printf("skipping %s; not a regular file", remote)
+		return nil
+	}
+	file, err := os.OpenFile(local, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if err := session.recv(remote, file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(local, info.ModTime(), info.ModTime())
+}
+
+// unixMode converts the st_mode reported by the device
+func unixMode(mode uint32) fs.FileMode {
+	converted := fs.FileMode(mode & 0777)
+	switch mode & 0170000 {
+	case 0040000:
+		converted |= fs.ModeDir
+	case 0120000:
+		converted |= fs.ModeSymlink
+	case 0010000:
+		converted |= fs.ModeNamedPipe
+	case 0140000:
+		converted |= fs.ModeSocket
+	case 0020000:
+		converted |= fs.ModeDevice | fs.ModeCharDevice
+	case 0060000:
+		converted |= fs.ModeDevice
+	}
+	if mode&04000 != 0 {
+		converted |= fs.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		converted |= fs.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		converted |= fs.ModeSticky
+	}
+	return converted
+}
+
+// unixPerm converts the permissions of a file being sent
+func unixPerm(mode fs.FileMode) uint32 {
+	perm := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		perm |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		perm |= 02000
+	}
+	if mode&fs.ModeSticky != 0 {
+		perm |= 01000
+	}
+	return perm
+}
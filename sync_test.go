@@ -0,0 +1,316 @@
+package adbtools
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncDaemon answers SYNC requests like adbd would
+type syncDaemon struct {
+	conn  net.Conn
+	files map[string][]byte
+	mu    sync.Mutex
+	sent  map[string]string
+}
+
+func newSyncSession(t *testing.T, files map[string][]byte) (*syncConn, *syncDaemon) {
+	client, server := net.Pipe()
+	daemon := &syncDaemon{conn: server, files: files, sent: map[string]string{}}
+	go daemon.serve()
+	t.Cleanup(func() { client.Close() })
+	return &syncConn{conn: client, cancel: func() {}}, daemon
+}
+
+func (daemon *syncDaemon) write(id string, fields ...interface{}) {
+	buffer := &bytes.Buffer{}
+	buffer.WriteString(id)
+	for _, field := range fields {
+		switch value := field.(type) {
+		case uint32:
+			binary.Write(buffer, binary.LittleEndian, value)
+		case string:
+			buffer.WriteString(value)
+		}
+	}
+	daemon.conn.Write(buffer.Bytes())
+}
+
+func (daemon *syncDaemon) serve() {
+	defer daemon.conn.Close()
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(daemon.conn, header); err != nil {
+			return
+		}
+		id := string(header[:4])
+		data := make([]byte, binary.LittleEndian.Uint32(header[4:]))
+		io.ReadFull(daemon.conn, data)
+		remote := string(data)
+		switch id {
+		case "STAT":
+			if content, ok := daemon.files[remote]; ok {
+				daemon.write("STAT", uint32(0100644), uint32(len(content)), uint32(1700000000))
+			} else if daemon.isDir(remote) {
+				daemon.write("STAT", uint32(040755), uint32(4096), uint32(1700000000))
+			} else {
+				daemon.write("STAT", uint32(0), uint32(0), uint32(0))
+			}
+		case "LIST":
+			if !daemon.isDir(remote) {
+				message := "No such file or directory"
+				daemon.write("FAIL", uint32(len(message)), message)
+				continue
+			}
+			for _, name := range append([]string{".", ".."}, daemon.children(remote)...) {
+				child := strings.TrimSuffix(remote, "/") + "/" + name
+				if content, ok := daemon.files[child]; ok {
+					daemon.write("DENT", uint32(0100644), uint32(len(content)), uint32(1700000000), uint32(len(name)), name)
+				} else {
+					daemon.write("DENT", uint32(040755), uint32(4096), uint32(1700000000), uint32(len(name)), name)
+				}
+			}
+			daemon.write("DONE", uint32(0), uint32(0), uint32(0), uint32(0))
+		case "RECV":
+			content, ok := daemon.files[remote]
+			if !ok {
+				message := "No such file or directory"
+				daemon.write("FAIL", uint32(len(message)), message)
+				continue
+			}
+			// split in two chunks
+			half := len(content) / 2
+			daemon.write("DATA", uint32(half), string(content[:half]))
+			daemon.write("DATA", uint32(len(content)-half), string(content[half:]))
+			daemon.write("DONE", uint32(0))
+		case "SEND":
+			content := &strings.Builder{}
+			for {
+				io.ReadFull(daemon.conn, header)
+				length := binary.LittleEndian.Uint32(header[4:])
+				if string(header[:4]) == "DONE" {
+					daemon.mu.Lock()
+					daemon.sent[remote] = content.String()
+					daemon.mu.Unlock()
+					daemon.write("OKAY", uint32(0))
+					break
+				}
+				io.CopyN(content, daemon.conn, int64(length))
+			}
+		case "QUIT":
+			return
+		}
+	}
+}
+
+// sentFiles returns the contents received so far, by "path,mode"
+func (daemon *syncDaemon) sentFiles() map[string]string {
+	daemon.mu.Lock()
+	defer daemon.mu.Unlock()
+	sent := map[string]string{}
+	for key, content := range daemon.sent {
+		sent[key] = content
+	}
+	return sent
+}
+
+func (daemon *syncDaemon) isDir(remote string) bool {
+	prefix := strings.TrimSuffix(remote, "/") + "/"
+	for file := range daemon.files {
+		if strings.HasPrefix(file, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// children lists the names of the files and directories right under remote
+func (daemon *syncDaemon) children(remote string) []string {
+	prefix := strings.TrimSuffix(remote, "/") + "/"
+	names := []string{}
+	seen := map[string]bool{}
+	for file := range daemon.files {
+		if !strings.HasPrefix(file, prefix) {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(file, prefix), "/", 2)[0]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func TestSyncStat(t *testing.T) {
+	session, _ := newSyncSession(t, map[string][]byte{
+		"/sdcard/a.txt":        []byte("hello"),
+		"/data/local/tmp/test": []byte("#!/bin/sh"),
+	})
+	defer session.Close()
+
+	info, err := session.stat("/sdcard/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "a.txt" || info.Size() != 5 || info.Mode() != 0644 || !info.ModTime().Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("stat = %s %d %v %v", info.Name(), info.Size(), info.Mode(), info.ModTime())
+	}
+	info, err = session.stat("/data/local/tmp")
+	if err != nil || !info.IsDir() || info.Mode().Perm() != 0755 {
+		t.Errorf("stat(dir) = %v, %v", info, err)
+	}
+	if _, err := session.stat("/sdcard/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("stat(missing) err = %v; want fs.ErrNotExist", err)
+	}
+}
+
+func TestSyncList(t *testing.T) {
+	session, _ := newSyncSession(t, map[string][]byte{
+		"/sdcard/b.txt":      []byte("b"),
+		"/sdcard/a.txt":      []byte("a"),
+		"/sdcard/DCIM/c.jpg": []byte("c"),
+	})
+	defer session.Close()
+
+	files, err := session.list("/sdcard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	if strings.Join(names, " ") != "DCIM a.txt b.txt" || !files[0].IsDir() || files[1].Mode() != 0644 {
+		t.Errorf("list = %v", names)
+	}
+	if _, err := session.list("/missing"); err == nil {
+		t.Error("list(missing) did not fail")
+	}
+}
+
+func TestSyncTransfer(t *testing.T) {
+	content := strings.Repeat("0123456789", syncChunk/5)
+	session, daemon := newSyncSession(t, map[string][]byte{"/sdcard/big.txt": []byte(content)})
+	defer session.Close()
+
+	received := &bytes.Buffer{}
+	if err := session.recv("/sdcard/big.txt", received); err != nil {
+		t.Fatal(err)
+	}
+	if received.String() != content {
+		t.Errorf("recv got %d bytes; want %d", received.Len(), len(content))
+	}
+	if err := session.recv("/sdcard/missing", received); err == nil || !strings.Contains(err.Error(), "No such file") {
+		t.Errorf("recv(missing) err = %v", err)
+	}
+
+	if err := session.send("/sdcard/copy.txt", strings.NewReader(content), 0644, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if sent := daemon.sentFiles()["/sdcard/copy.txt,33188"]; sent != content {
+		t.Errorf("send delivered %d bytes; want %d", len(sent), len(content))
+	}
+}
+
+func TestPush(t *testing.T) {
+	mkdirs := filepath.Join(t.TempDir(), "mkdirs")
+	fakeADB(t, `echo "$*" >> `+mkdirs)
+	sent := startADBServer(t, map[string][]byte{"/data/local/tmp/previous.txt": []byte("old")})
+
+	local := filepath.Join(t.TempDir(), "fixtures")
+	for _, dir := range []string{"empty", "bin"} {
+		if err := os.MkdirAll(filepath.Join(local, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(local, "data.txt"), []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(local, "bin", "tool"), []byte("#!/bin/sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	device := &Device{}
+	// an existing remote directory receives the local directory by its name
+	if err := device.Push(local, "/data/local/tmp", 0); err != nil {
+		t.Fatal(err)
+	}
+	// a missing remote path is the target itself; special bits are kept
+	if err := device.Push(filepath.Join(local, "bin", "tool"), "/data/local/tmp/su-tool", fs.ModeSetuid|0755); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"/data/local/tmp/fixtures/data.txt," + strconv.Itoa(0100600): "data",
+		"/data/local/tmp/fixtures/bin/tool," + strconv.Itoa(0100755): "#!/bin/sh",
+		"/data/local/tmp/su-tool," + strconv.Itoa(0104755):           "#!/bin/sh",
+	}
+	if got := sent(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Push sent %v; want %v", got, want)
+	}
+	command, _ := os.ReadFile(mkdirs)
+	if !strings.Contains(string(command), "mkdir -p '/data/local/tmp/fixtures/empty'") {
+		t.Errorf("the empty directory was not created; commands: %q", command)
+	}
+}
+
+func TestRemoveMkdirQuoting(t *testing.T) {
+	fakeDeviceShell(t)
+	dir := t.TempDir()
+	for _, name := range []string{"My Files", "My", "Files"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	device := &Device{}
+	if err := device.Remove(filepath.Join(dir, "My Files")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "My Files")); !os.IsNotExist(err) {
+		t.Error("Remove left the directory behind")
+	}
+	for _, name := range []string{"My", "Files"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Remove deleted %s: %v", name, err)
+		}
+	}
+
+	created := filepath.Join(dir, "it's new", "$HOME")
+	if err := device.Mkdir(created); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(created); err != nil || !info.IsDir() {
+		t.Errorf("Mkdir did not create %s: %v", created, err)
+	}
+}
+
+func TestUnixMode(t *testing.T) {
+	tests := []struct {
+		mode uint32
+		want fs.FileMode
+	}{
+		{0100644, 0644},
+		{040755, fs.ModeDir | 0755},
+		{0120777, fs.ModeSymlink | 0777},
+		{041777, fs.ModeDir | fs.ModeSticky | 0777},
+	}
+	for _, test := range tests {
+		if got := unixMode(test.mode); got != test.want {
+			t.Errorf("unixMode(%o) = %v; want %v", test.mode, got, test.want)
+		}
+	}
+	if got := unixPerm(fs.ModeSetuid | 0755); got != 04755 {
+		t.Errorf("unixPerm = %o; want 4755", got)
+	}
+}
@@ -0,0 +1,231 @@
+package adbtools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	shell "github.com/ozzono/go-shell"
+)
+
+// Device states as reported by adb devices
+const (
+	StateDevice        = "device"
+	StateOffline       = "offline"
+	StateUnauthorized  = "unauthorized"
+	StateRecovery      = "recovery"
+	StateSideload      = "sideload"
+	StateBootloader    = "bootloader"
+	StateNoPermissions = "no permissions"
+)
+
+// DeviceInfo is an entry of adb devices -l
+type DeviceInfo struct {
+	Serial      string
+	State       string
+	Product     string
+	Model       string
+	Device      string
+	TransportID string
+	USB         string
+}
+
+// DeviceEventType tells what happened to a tracked device
+type DeviceEventType int
+
+// Device event types
+const (
+	DeviceAdded DeviceEventType = iota
+	DeviceRemoved
+	DeviceStateChanged
+)
+
+func (eventType DeviceEventType) String() string {
+	switch eventType {
+	case DeviceAdded:
+		return "added"
+	case DeviceRemoved:
+		return "removed"
+	case DeviceStateChanged:
+		return "state changed"
+	}
+	return "unknown"
+}
+
+// DeviceEvent is a change in the attached devices
+type DeviceEvent struct {
+	Type DeviceEventType
+	Info DeviceInfo
+	// OldState is the previous state of a changed or removed device
+	OldState string
+}
+
+// ListDevices returns every device known by adb, whatever its state
+func ListDevices() ([]DeviceInfo, error) {
+	out, err := shell.Cmd("adb devices -l")
+	if err != nil {
+		return nil, fmt.Errorf("shell.Cmd err: %v", err)
+	}
+	return parseDevicesList(out), nil
+}
+
+// TrackDevices reports every attach, detach and state change of the devices
+// until the context is done, when the channel is closed.
+//
+// The devices attached when tracking starts are reported as added.
+// It uses the adb server host:track-devices-l service and
+// falls back to polling adb devices -l when the server is unreachable
+func TrackDevices(ctx context.Context) (<-chan DeviceEvent, error) {
+	events := make(chan DeviceEvent)
+	conn, err := dialADB(ctx)
+	if err == nil {
+		if err = sendRequest(conn, "host:track-devices-l"); err != nil {
+			conn.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("unable to track devices with adb server; polling instead: %v", err)
+		if _, err := ListDevices(); err != nil {
+			return nil, err
+		}
+		go pollDevices(ctx, events)
+		return events, nil
+	}
+	go func() {
+		defer close(events)
+		known := map[string]DeviceInfo{}
+		for {
+			message, err := readMessage(conn)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("device tracking stopped: %v", err)
+				}
+				return
+			}
+			current := parseDevicesList(message)
+			for _, event := range diffDevices(known, current) {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			known = devicesMap(current)
+		}
+	}()
+	return events, nil
+}
+
+func pollDevices(ctx context.Context, events chan<- DeviceEvent) {
+	defer close(events)
+	known := map[string]DeviceInfo{}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		current, err := ListDevices()
+		if err != nil {
+			log.Printf("ListDevices err: %v", err)
+		} else {
+			for _, event := range diffDevices(known, current) {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			known = devicesMap(current)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// diffDevices lists the events that turn the known devices into the current ones
+func diffDevices(known map[string]DeviceInfo, current []DeviceInfo) []DeviceEvent {
+	events := []DeviceEvent{}
+	currentMap := devicesMap(current)
+	for _, info := range current {
+		old, ok := known[info.Serial]
+		if !ok {
+			events = append(events, DeviceEvent{Type: DeviceAdded, Info: info})
+		} else if old.State != info.State {
+			events = append(events, DeviceEvent{Type: DeviceStateChanged, Info: info, OldState: old.State})
+		}
+	}
+	removed := []string{}
+	for serial := range known {
+		if _, ok := currentMap[serial]; !ok {
+			removed = append(removed, serial)
+		}
+	}
+	sort.Strings(removed)
+	for _, serial := range removed {
+		events = append(events, DeviceEvent{Type: DeviceRemoved, Info: known[serial], OldState: known[serial].State})
+	}
+	return events
+}
+
+func devicesMap(devices []DeviceInfo) map[string]DeviceInfo {
+	output := map[string]DeviceInfo{}
+	for _, info := range devices {
+		output[info.Serial] = info
+	}
+	return output
+}
+
+// parseDevicesList parses the adb devices -l output
+//
+// The state may hold spaces, as in "no permissions (...)",
+// so it is made of every field before the first known key
+func parseDevicesList(output string) []DeviceInfo {
+	devices := []DeviceInfo{}
+	for _, row := range strings.Split(output, "\n") {
+		row = strings.TrimSpace(row)
+		if len(row) == 0 || strings.HasPrefix(row, "List of devices") || strings.HasPrefix(row, "*") {
+			continue
+		}
+		fields := strings.Fields(row)
+		if len(fields) < 2 {
+			continue
+		}
+		info := DeviceInfo{Serial: fields[0]}
+		state := []string{}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, ":", 2)
+			if len(kv) == 2 {
+				switch kv[0] {
+				case "product":
+					info.Product = kv[1]
+					continue
+				case "model":
+					info.Model = kv[1]
+					continue
+				case "device":
+					info.Device = kv[1]
+					continue
+				case "transport_id":
+					info.TransportID = kv[1]
+					continue
+				case "usb":
+					info.USB = kv[1]
+					continue
+				}
+			}
+			if len(info.Product+info.Model+info.Device+info.TransportID+info.USB) == 0 {
+				state = append(state, field)
+			}
+		}
+		info.State = strings.Join(state, " ")
+		if strings.HasPrefix(info.State, StateNoPermissions) {
+			info.State = StateNoPermissions
+		}
+		devices = append(devices, info)
+	}
+	return devices
+}
@@ -0,0 +1,81 @@
+package adbtools
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const devicesList = `List of devices attached
+emulator-5554          device product:sdk_gphone_x86 model:Android_SDK_built_for_x86 device:generic_x86 transport_id:1
+0123456789ABCDEF       unauthorized usb:1-1 transport_id:2
+R58M123456X            no permissions (user in plugdev group; are your udev rules wrong?); see [http://developer.android.com/tools/device.html] usb:1-2 transport_id:3
+192.168.0.10:5555      offline product:walleye model:Pixel_2 device:walleye transport_id:4
+`
+
+func TestParseDevicesList(t *testing.T) {
+	want := []DeviceInfo{
+		{Serial: "emulator-5554", State: StateDevice, Product: "sdk_gphone_x86", Model: "Android_SDK_built_for_x86", Device: "generic_x86", TransportID: "1"},
+		{Serial: "0123456789ABCDEF", State: StateUnauthorized, USB: "1-1", TransportID: "2"},
+		{Serial: "R58M123456X", State: StateNoPermissions, USB: "1-2", TransportID: "3"},
+		{Serial: "192.168.0.10:5555", State: StateOffline, Product: "walleye", Model: "Pixel_2", Device: "walleye", TransportID: "4"},
+	}
+	got := parseDevicesList(devicesList)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected devices:\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestDiffDevices(t *testing.T) {
+	known := devicesMap([]DeviceInfo{
+		{Serial: "emulator-5554", State: StateDevice},
+		{Serial: "0123456789ABCDEF", State: StateUnauthorized},
+	})
+	events := diffDevices(known, []DeviceInfo{
+		{Serial: "0123456789ABCDEF", State: StateDevice},
+		{Serial: "192.168.0.10:5555", State: StateDevice},
+	})
+	want := []DeviceEvent{
+		{Type: DeviceStateChanged, Info: DeviceInfo{Serial: "0123456789ABCDEF", State: StateDevice}, OldState: StateUnauthorized},
+		{Type: DeviceAdded, Info: DeviceInfo{Serial: "192.168.0.10:5555", State: StateDevice}},
+		{Type: DeviceRemoved, Info: DeviceInfo{Serial: "emulator-5554", State: StateDevice}, OldState: StateDevice},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("unexpected events:\n got: %+v\nwant: %+v", events, want)
+	}
+}
+
+func TestTrackDevicesClosesRefusedConnection(t *testing.T) {
+	fakeADB(t, `echo "List of devices attached"`)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	t.Setenv("ADB_SERVER_SOCKET", "tcp:"+listener.Addr().String())
+	closed := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		readMessage(conn)
+		conn.Write([]byte("FAIL0007refused"))
+		io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := TrackDevices(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Error("the refused tracking connection was left open")
+	}
+}
@@ -0,0 +1,225 @@
+package adbtools
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+)
+
+// WatcherAction handles the screen matched by a watcher.
+// Returning an error fails the ongoing wait with a *WatcherError
+type WatcherAction func(device *Device, screen string) error
+
+// WatcherError reports the watcher that failed a wait
+type WatcherError struct {
+	Watcher string
+	Err     error
+}
+
+type watcherSet struct {
+	mu       sync.Mutex
+	watchers []*watcher
+	running  bool
+}
+
+type watcher struct {
+	name   string
+	match  *regexp.Regexp
+	action WatcherAction
+	count  int
+}
+
+func (err *WatcherError) Error() string {
+	return fmt.Sprintf("watcher %s: %v", err.Watcher, err.Err)
+}
+
+// TapAction taps the center of the bounds captured by the expression,
+// as in `text="OK"[^>]*bounds="(\[\d+,\d+\]\[\d+,\d+\])"`
+func TapAction(expression string) WatcherAction {
+	re := regexp.MustCompile(expression)
+	return func(device *Device, screen string) error {
+		matches := re.FindStringSubmatch(screen)
+		if len(matches) < 2 {
+			return fmt.Errorf("unable to find match for exp %s", expression)
+		}
+		coords, err := XMLtoCoords(matches[1])
+		if err != nil {
+			return err
+		}
+		device.TapScreen(coords[0], coords[1], 10)
+		return nil
+	}
+}
+
+// BackAction presses the back button
+func BackAction() WatcherAction {
+	return func(device *Device, screen string) error {
+		device.Shell(device.input("keyevent KEYCODE_BACK"))
+		return nil
+	}
+}
+
+// FailAction fails the ongoing wait with the given message
+func FailAction(message string) WatcherAction {
+	return func(device *Device, screen string) error {
+		return fmt.Errorf("%s", message)
+	}
+}
+
+// RegisterWatcher registers a watcher handling unexpected dialogs,
+// such as ANRs, permission prompts or rating popups.
+//
+// The watchers are checked in the order they were registered whenever
+// XMLScreen dumps a new screen, and on every WaitApp attempt;
+// the first one whose expression matches the screen dump runs its action.
+// Registering an existing name replaces that watcher
+func (device *Device) RegisterWatcher(name, expression string, action WatcherAction) error {
+	if len(name) == 0 || action == nil {
+		return fmt.Errorf("invalid watcher; requires a name and an action")
+	}
+	re, err := regexp.Compile(expression)
+	if err != nil {
+		return fmt.Errorf("invalid watcher expression: %v", err)
+	}
+	set := device.loadWatchers(true)
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	for _, item := range set.watchers {
+		if item.name == name {
+			item.match = re
+			item.action = action
+			return nil
+		}
+	}
+	set.watchers = append(set.watchers, &watcher{name: name, match: re, action: action})
+	return nil
+}
+
+// RemoveWatcher unregisters the named watcher
+func (device *Device) RemoveWatcher(name string) {
+	set := device.loadWatchers(false)
+	if set == nil {
+		return
+	}
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	for i, item := range set.watchers {
+		if item.name == name {
+			set.watchers = append(set.watchers[:i], set.watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+// WatcherCounts returns how many times each registered watcher fired
+func (device *Device) WatcherCounts() map[string]int {
+	counts := map[string]int{}
+	set := device.loadWatchers(false)
+	if set == nil {
+		return counts
+	}
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	for _, item := range set.watchers {
+		counts[item.name] = item.count
+	}
+	return counts
+}
+
+// ResetWatchers zeroes the counters
+func (device *Device) ResetWatchers() {
+	set := device.loadWatchers(false)
+	if set == nil {
+		return
+	}
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	for _, item := range set.watchers {
+		item.count = 0
+	}
+}
+
+func (device *Device) loadWatchers(create bool) *watcherSet {
+	var set *watcherSet
+	device.locked(func() {
+		if device.watchers == nil && create {
+			device.watchers = &watcherSet{}
+		}
+		set = device.watchers
+	})
+	return set
+}
+
+// runWatchers runs the action of the first watcher matching the screen.
+// Actions that dump the screen themselves do not trigger the watchers again,
+// and no action runs while the device is still booting
+func (device *Device) runWatchers(screen string) (bool, error) {
+	set := device.loadWatchers(false)
+	if set == nil {
+		return false, nil
+	}
+	set.mu.Lock()
+	if set.running {
+		set.mu.Unlock()
+		return false, nil
+	}
+	var fired *watcher
+	for _, item := range set.watchers {
+		if item.match.MatchString(screen) {
+			fired = item
+			break
+		}
+	}
+	if fired == nil {
+		set.mu.Unlock()
+		return false, nil
+	}
+	set.running = true
+	set.mu.Unlock()
+	defer func() {
+		set.mu.Lock()
+		set.running = false
+		set.mu.Unlock()
+	}()
+
+	// boot time dialogs, such as the setup wizard, are not for the watchers
+	if !device.DeviceReady() {
+		return false, nil
+	}
+	set.mu.Lock()
+	fired.count++
+	name, action := fired.name, fired.action
+	set.mu.Unlock()
+
+	if device.Log {
+		log.Printf("watcher %s fired", name)
+	}
+	if err := action(device, screen); err != nil {
+		return true, &WatcherError{Watcher: name, Err: err}
+	}
+	return true, nil
+}
+
+// watchScreen dumps the screen on behalf of waits that do not dump it
+// themselves to run the watchers, if any, and reports the failure of a watcher
+func (device *Device) watchScreen() error {
+	set := device.loadWatchers(false)
+	if set == nil {
+		return nil
+	}
+	set.mu.Lock()
+	idle := len(set.watchers) > 0 && !set.running
+	set.mu.Unlock()
+	if !idle {
+		return nil
+	}
+	_, err := device.XMLScreen(true)
+	if failure, ok := err.(*WatcherError); ok {
+		return failure
+	}
+	if err != nil {
+		log.Printf("watchers XMLScreen err: %v", err)
+	}
+	return nil
+}
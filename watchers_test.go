@@ -0,0 +1,108 @@
+package adbtools
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunWatchers(t *testing.T) {
+	fakeADB(t, `case "$*" in
+*"getprop sys.boot_completed"*) echo 1;;
+*"uiautomator dump"*) echo "UI hierchary dumped to: /sdcard/window_dump.xml";;
+*"cat /sdcard/window_dump.xml"*) echo "<node text=\"Chrome isn't responding\"/>";;
+esac`)
+	device := &Device{DefaultSleep: 1}
+	if fired, err := device.runWatchers(`<node text="OK"/>`); fired || err != nil {
+		t.Fatalf("runWatchers without watchers = %v, %v", fired, err)
+	}
+
+	nested := false
+	dismiss := func(device *Device, screen string) error {
+		// actions dumping the screen must not run the watchers again
+		nested, _ = device.runWatchers(screen)
+		return nil
+	}
+	if err := device.RegisterWatcher("rating", `text="Rate us"`, dismiss); err != nil {
+		t.Fatal(err)
+	}
+	if err := device.RegisterWatcher("anr", `isn't responding`, FailAction("app not responding")); err != nil {
+		t.Fatal(err)
+	}
+	if err := device.RegisterWatcher("invalid", `(`, BackAction()); err == nil {
+		t.Error("RegisterWatcher accepted an invalid expression")
+	}
+
+	fired, err := device.runWatchers(`<node text="Rate us"/><node text="Chrome isn't responding"/>`)
+	if !fired || err != nil || nested {
+		t.Errorf("runWatchers(rating) = %v, %v; nested %v", fired, err, nested)
+	}
+	fired, err = device.runWatchers(`<node text="Chrome isn't responding"/>`)
+	if watcherErr, ok := err.(*WatcherError); !fired || !ok || watcherErr.Watcher != "anr" {
+		t.Errorf("runWatchers(anr) = %v, %v; want a *WatcherError of anr", fired, err)
+	}
+	if watcherErr, ok := device.watchScreen().(*WatcherError); !ok || watcherErr.Watcher != "anr" {
+		t.Errorf("watchScreen did not report the anr failure")
+	}
+
+	counts := device.WatcherCounts()
+	if counts["rating"] != 1 || counts["anr"] != 2 {
+		t.Errorf("WatcherCounts = %v; want rating:1 anr:2", counts)
+	}
+	device.RemoveWatcher("anr")
+	device.ResetWatchers()
+	if counts := device.WatcherCounts(); len(counts) != 1 || counts["rating"] != 0 {
+		t.Errorf("WatcherCounts after reset = %v; want rating:0", counts)
+	}
+}
+
+func TestRunWatchersWhileBooting(t *testing.T) {
+	fakeADB(t, `case "$*" in
+*"getprop sys.boot_completed"*) echo;;
+esac`)
+	device := &Device{}
+	if err := device.RegisterWatcher("anr", `isn't responding`, FailAction("app not responding")); err != nil {
+		t.Fatal(err)
+	}
+	if fired, err := device.runWatchers(`<node text="System UI isn't responding"/>`); fired || err != nil {
+		t.Errorf("runWatchers while booting = %v, %v; want no action", fired, err)
+	}
+	if counts := device.WatcherCounts(); counts["anr"] != 0 {
+		t.Errorf("WatcherCounts while booting = %v; want anr:0", counts)
+	}
+}
+
+func TestWaitInScreenFiresWatchersOnce(t *testing.T) {
+	dir := t.TempDir()
+	// the rating dialog shows until the watcher dismisses it
+	fakeADB(t, `case "$*" in
+*"getprop sys.boot_completed"*) echo 1;;
+*"uiautomator dump"*) echo dump >> `+dir+`/dumps; echo "UI hierchary dumped to: /sdcard/window_dump.xml";;
+*"cat /sdcard/window_dump.xml"*) if [ -f `+dir+`/dismissed ]; then echo '<node text="Welcome"/>'; else echo '<node text="Rate us"/>'; fi;;
+*"keyevent KEYCODE_BACK"*) touch `+dir+`/dismissed;;
+esac`)
+	device := &Device{DefaultSleep: 1}
+	if err := device.RegisterWatcher("rating", `text="Rate us"`, BackAction()); err != nil {
+		t.Fatal(err)
+	}
+	if err := device.WaitInScreen(3, "welcome"); err != nil {
+		t.Fatal(err)
+	}
+	if counts := device.WatcherCounts(); counts["rating"] != 1 {
+		t.Errorf("WatcherCounts = %v; want rating:1", counts)
+	}
+	// the dialog dump and the dump after dismissing it
+	if dumps, _ := os.ReadFile(filepath.Join(dir, "dumps")); strings.Count(string(dumps), "dump") != 2 {
+		t.Errorf("got %d screen dumps; want 2", strings.Count(string(dumps), "dump"))
+	}
+
+	device.RegisterWatcher("rating", `text="Welcome"`, FailAction("unexpected welcome"))
+	device.ResetWatchers()
+	if err, ok := device.WaitInScreen(3, "missing").(*WatcherError); !ok || err.Watcher != "rating" {
+		t.Errorf("WaitInScreen = %v; want the rating *WatcherError", err)
+	}
+	if counts := device.WatcherCounts(); counts["rating"] != 1 {
+		t.Errorf("WatcherCounts after failing = %v; want rating:1", counts)
+	}
+}
@@ -0,0 +1,189 @@
+package adbtools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	shell "github.com/ozzono/go-shell"
+)
+
+var (
+	routeSource = regexp.MustCompile(`\bsrc (\d+\.\d+\.\d+\.\d+)`)
+	inetAddress = regexp.MustCompile(`inet (\d+\.\d+\.\d+\.\d+)/`)
+)
+
+// Connect attaches a device through the network with the host:port format
+func Connect(address string) error {
+	out, err := shell.Cmd("adb connect " + address)
+	if err != nil {
+		return fmt.Errorf("shell.Cmd err: %v", err)
+	}
+	if !strings.Contains(out, "connected to") {
+		return fmt.Errorf("Failed to connect to %s; output: %s", address, out)
+	}
+	return nil
+}
+
+// Disconnect detaches a network device with the host:port format
+func Disconnect(address string) error {
+	out, err := shell.Cmd("adb disconnect " + address)
+	if err != nil {
+		return fmt.Errorf("shell.Cmd err: %v", err)
+	}
+	if !strings.Contains(out, "disconnected") {
+		return fmt.Errorf("Failed to disconnect from %s; output: %s", address, out)
+	}
+	return nil
+}
+
+// Pair pairs with a device using Android 11+ wireless debugging.
+//
+// The address and the six digit code are the ones shown by the device at
+// Developer options > Wireless debugging > Pair device with pairing code.
+// After pairing, Connect uses the address shown at Wireless debugging, not the pairing one
+func Pair(address, code string) error {
+	out, err := shell.Cmd(fmt.Sprintf("adb pair %s %s", address, code))
+	if err != nil {
+		return fmt.Errorf("shell.Cmd err: %v", err)
+	}
+	if !strings.Contains(out, "Successfully paired") {
+		return fmt.Errorf("Failed to pair with %s; output: %s", address, out)
+	}
+	return nil
+}
+
+// TcpIP restarts the device adb daemon listening to the given port
+// and returns the address to be used with Connect.
+//
+// The device must be in the same network as the host
+func (device *Device) TcpIP(port int) (string, error) {
+	if device.Log {
+		log.Printf("switching %s to tcp mode on port %d", device.ID, port)
+	}
+	ip, err := device.IPAddress()
+	if err != nil {
+		return "", err
+	}
+	output := device.Shell(fmt.Sprintf("adb tcpip %d", port))
+	if !strings.Contains(output, "restarting in TCP mode") {
+		return "", fmt.Errorf("Failed to restart adb in tcp mode; output: %s", output)
+	}
+	return fmt.Sprintf("%s:%d", ip, port), nil
+}
+
+// IPAddress returns the IPv4 address of the device default route interface,
+// which is usually wlan0 but varies across vendors
+func (device *Device) IPAddress() (string, error) {
+	route := device.Shell("adb shell ip route get 1")
+	if ip, ok := parseIPAddress(route, ""); ok {
+		return ip, nil
+	}
+	addresses := device.Shell("adb shell ip -f inet addr show")
+	if ip, ok := parseIPAddress("", addresses); ok {
+		return ip, nil
+	}
+	return "", fmt.Errorf("Failed to fetch the device ip address; is wifi on? output: %s%s", route, addresses)
+}
+
+// parseIPAddress reads the source address of ip route get, or else
+// the first non loopback address of ip addr show
+func parseIPAddress(route, addresses string) (string, bool) {
+	if matches := routeSource.FindStringSubmatch(route); len(matches) > 0 {
+		return matches[1], true
+	}
+	for _, matches := range inetAddress.FindAllStringSubmatch(addresses, -1) {
+		if !strings.HasPrefix(matches[1], "127.") {
+			return matches[1], true
+		}
+	}
+	return "", false
+}
+
+// KeepConnected reconnects the network device whenever it drops or goes offline,
+// until the context is done. When the adb server restarts, the tracking resumes
+// with the new server and the device is reconnected.
+//
+// Reconnection attempts are spaced with an exponential backoff
+// starting at one second and limited to maxBackoff
+func KeepConnected(ctx context.Context, address string, maxBackoff time.Duration) error {
+	events, err := TrackDevices(ctx)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			for event := range events {
+				if event.Info.Serial != address {
+					continue
+				}
+				if event.Type == DeviceRemoved || event.Info.State == StateOffline {
+					log.Printf("%s dropped; reconnecting", address)
+					reconnect(ctx, address, maxBackoff)
+				}
+			}
+			if events = retrack(ctx, maxBackoff); events == nil {
+				return
+			}
+			// the device may have dropped while untracked
+			reconnect(ctx, address, maxBackoff)
+		}
+	}()
+	return nil
+}
+
+// retrack tracks the devices again after the tracking stopped,
+// returning nil when the context is done first
+func retrack(ctx context.Context, maxBackoff time.Duration) <-chan DeviceEvent {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		events, err := TrackDevices(ctx)
+		if err == nil {
+			return events
+		}
+		log.Printf("TrackDevices err: %v; retrying in %v", err, backoff)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = nextBackoff(backoff, maxBackoff)
+	}
+	return nil
+}
+
+// reconnect connects the address again, dropping its stale transport first;
+// adb answers "already connected" for offline transports too
+func reconnect(ctx context.Context, address string, maxBackoff time.Duration) {
+	backoff := time.Second
+	device := &Device{ID: address}
+	for {
+		Disconnect(address)
+		err := Connect(address)
+		if err == nil && !device.Online() {
+			err = fmt.Errorf("%s is not online after connecting", address)
+		}
+		if err == nil {
+			log.Printf("reconnected to %s", address)
+			return
+		}
+		log.Printf("Connect err: %v; retrying in %v", err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = nextBackoff(backoff, maxBackoff)
+	}
+}
+
+// nextBackoff doubles the backoff up to maxBackoff; a zero maxBackoff means no limit
+func nextBackoff(backoff, maxBackoff time.Duration) time.Duration {
+	backoff *= 2
+	if maxBackoff > 0 && backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,142 @@
+package adbtools
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWirelessOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		run    func() error
+		ok     bool
+	}{
+		{"connect", "connected to 192.168.1.23:5555", func() error { return Connect("192.168.1.23:5555") }, true},
+		{"reconnect", "already connected to 192.168.1.23:5555", func() error { return Connect("192.168.1.23:5555") }, true},
+		{"connect refused", "failed to connect to '192.168.1.23:5555': Connection refused", func() error { return Connect("192.168.1.23:5555") }, false},
+		{"pair", "Successfully paired to 192.168.1.23:37123 [guid=adb-1A2B3C-xyz]", func() error { return Pair("192.168.1.23:37123", "123456") }, true},
+		{"pair wrong code", "Failed: Wrong password or connection was dropped.", func() error { return Pair("192.168.1.23:37123", "000000") }, false},
+		{"disconnect", "disconnected 192.168.1.23:5555", func() error { return Disconnect("192.168.1.23:5555") }, true},
+		{"disconnect unknown", "error: no such device '192.168.1.23:5555'", func() error { return Disconnect("192.168.1.23:5555") }, false},
+	}
+	for _, test := range tests {
+		fakeADB(t, fmt.Sprintf("echo %q", test.output))
+		if err := test.run(); (err == nil) != test.ok {
+			t.Errorf("%s with output %q: err = %v", test.name, test.output, err)
+		}
+	}
+}
+
+func TestParseIPAddress(t *testing.T) {
+	tests := []struct {
+		route     string
+		addresses string
+		want      string
+		ok        bool
+	}{
+		{"1.0.0.0 via 192.168.1.1 dev wlan0 table 1021 src 192.168.1.23 uid 2000\n", "", "192.168.1.23", true},
+		// some vendors name the interface differently
+		{"1.0.0.0 via 10.0.2.2 dev eth0 table 1003 src 10.0.2.15 uid 2000\n", "", "10.0.2.15", true},
+		{"RTNETLINK answers: Network is unreachable\n", "1: lo: <LOOPBACK,UP,LOWER_UP>\n    inet 127.0.0.1/8 scope host lo\n" +
+			"23: swlan0: <BROADCAST,UP>\n    inet 192.168.43.10/24 brd 192.168.43.255 scope global swlan0\n", "192.168.43.10", true},
+		{"RTNETLINK answers: Network is unreachable\n", "    inet 127.0.0.1/8 scope host lo\n", "", false},
+	}
+	for _, test := range tests {
+		got, ok := parseIPAddress(test.route, test.addresses)
+		if got != test.want || ok != test.ok {
+			t.Errorf("parseIPAddress(%q, %q) = %s, %v; want %s, %v", test.route, test.addresses, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	tests := []struct {
+		backoff, max, want time.Duration
+	}{
+		{time.Second, 0, 2 * time.Second},
+		{time.Second, time.Minute, 2 * time.Second},
+		{16 * time.Second, 20 * time.Second, 20 * time.Second},
+		{20 * time.Second, 20 * time.Second, 20 * time.Second},
+	}
+	for _, test := range tests {
+		if got := nextBackoff(test.backoff, test.max); got != test.want {
+			t.Errorf("nextBackoff(%v, %v) = %v; want %v", test.backoff, test.max, got, test.want)
+		}
+	}
+}
+
+func TestKeepConnectedRetracks(t *testing.T) {
+	connects := filepath.Join(t.TempDir(), "connects")
+	fakeADB(t, `echo "$*" >> `+connects+`
+case "$*" in
+*get-state) echo device;;
+connect*) echo "connected to $2";;
+esac`)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	t.Setenv("ADB_SERVER_SOCKET", "tcp:"+listener.Addr().String())
+	tracks := make(chan struct{}, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			readMessage(conn)
+			tracks <- struct{}{}
+			conn.Write([]byte("OKAY"))
+			// the first server goes away; the next one keeps tracking
+			if len(tracks) == 1 {
+				conn.Close()
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := KeepConnected(ctx, "192.168.1.23:5555", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		command, _ := os.ReadFile(connects)
+		if strings.Contains(string(command), "get-state") {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("the device was not reconnected after the tracking stopped; %d track requests", len(tracks))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if len(tracks) != 2 {
+		t.Errorf("got %d track requests; want 2", len(tracks))
+	}
+}
+
+func TestReconnectStaleTransport(t *testing.T) {
+	dir := t.TempDir()
+	// the stale transport stays offline until disconnected, yet adb answers already connected
+	fakeADB(t, `echo "$*" >> `+dir+`/commands
+case "$*" in
+disconnect*) touch `+dir+`/dropped; echo "disconnected $2";;
+connect*) echo "already connected to $2";;
+*get-state) if [ -f `+dir+`/dropped ]; then echo device; else echo offline; fi;;
+esac`)
+	reconnect(context.Background(), "192.168.1.23:5555", time.Second)
+	commands, _ := os.ReadFile(filepath.Join(dir, "commands"))
+	want := "disconnect 192.168.1.23:5555\nconnect 192.168.1.23:5555\n-s 192.168.1.23:5555 get-state\n"
+	if string(commands) != want {
+		t.Errorf("reconnect ran %q; want %q", commands, want)
+	}
+}